@@ -0,0 +1,352 @@
+package rasterm
+
+import (
+	"bufio"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Glyphs BlocksWriteImage draws with.
+type BlockMode int
+
+const (
+	BLOCKS_HALF     BlockMode = iota // ▀: 1x2 pixels per cell, exact colors
+	BLOCKS_QUADRANT                  // ▘▝▖▗ & co: 2x2, two colors per cell
+	BLOCKS_SEXTANT                   // Unicode 13 sextants: 2x3, two colors per cell
+)
+
+// pixels per cell, across & down
+func (m BlockMode) grid() (int, int) {
+	switch m {
+	case BLOCKS_QUADRANT:
+		return 2, 2
+	case BLOCKS_SEXTANT:
+		return 2, 3
+	}
+	return 1, 2
+}
+
+// Colors a text renderer may use.
+type ColorDepth int
+
+const (
+	COLOR_AUTO ColorDepth = iota // by COLORTERM & TERM, see EnvColorDepth
+	COLOR_TRUE                   // 24-bit SGR
+	COLOR_256                    // xterm 256-color palette
+	COLOR_16                     // ANSI 16 colors
+)
+
+/*
+Color depth the environment claims: COLOR_TRUE for COLORTERM=truecolor
+(or 24bit), COLOR_256 for a TERM ending in 256color, COLOR_16
+otherwise.
+*/
+func EnvColorDepth() ColorDepth {
+
+	switch lcaseEnv("COLORTERM") {
+	case "truecolor", "24bit":
+		return COLOR_TRUE
+	}
+
+	if strings.HasSuffix(lcaseEnv("TERM"), "256color") {
+		return COLOR_256
+	}
+
+	return COLOR_16
+}
+
+type BlocksOpts struct {
+	// Output size in terminal cells.  Zero Cols / Rows follow from the
+	// other, keeping the aspect ratio, assuming cells twice as tall as
+	// wide.  Both zero: one cell per two pixels down, one pixel across.
+	Cols int
+	Rows int
+
+	// Color transparent pixels are flattened onto.  Defaults to black.
+	Matte color.Color
+
+	// How the image is resampled to the cells.  Defaults to
+	// RESAMPLE_AUTO.
+	Resampler Resampler
+
+	// Defaults to BLOCKS_HALF, which any Unicode font has.
+	Mode BlockMode
+
+	// Defaults to EnvColorDepth().
+	Colors ColorDepth
+}
+
+/*
+Draws an image as text, for terminals with no graphics protocol.  With
+BLOCKS_HALF, each cell is an upper half block (▀) with the top pixel as
+foreground & the bottom pixel as background.  Quadrants & sextants
+show more detail: each cell gets the glyph & foreground / background
+pair that best match its pixels.
+
+Colors are 24-bit, or mapped to the nearest of the 256- or 16-color
+palettes per opts.Colors.  Each row ends with an SGR reset & newline.
+*/
+func BlocksWriteImage(out io.Writer, iImg image.Image, opts BlocksOpts) error {
+
+	b := iImg.Bounds()
+	if b.Empty() {
+		return nil
+	}
+
+	cols, rows := opts.Cols, opts.Rows
+	switch {
+	case (cols <= 0) && (rows <= 0):
+		cols, rows = b.Dx(), (b.Dy()+1)/2
+	case cols <= 0:
+		cols = (rows*2*b.Dx() + b.Dy()/2) / b.Dy()
+	case rows <= 0:
+		rows = (cols*b.Dy() + b.Dx()) / (2 * b.Dx())
+	}
+	if cols < 1 {
+		cols = 1
+	}
+	if rows < 1 {
+		rows = 1
+	}
+
+	depth := opts.Colors
+	if depth == COLOR_AUTO {
+		depth = EnvColorDepth()
+	}
+
+	gx, gy := opts.Mode.grid()
+	iImg = Scale(iImg, ScaleOpts{Width: cols * gx, Height: rows * gy, Fit: FIT_FILL, Resampler: opts.Resampler})
+	iImg = flattenAlpha(iImg, opts.Matte)
+	b = iImg.Bounds()
+
+	var cell [6]rgb
+	bw := bufio.NewWriter(out)
+	tmp := make([]byte, 0, 48)
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+
+			for ix := 0; ix < gx*gy; ix++ {
+				r, g, bl, _ := iImg.At(b.Min.X+x*gx+ix%gx, b.Min.Y+y*gy+ix/gx).RGBA()
+				cell[ix] = rgb{int32(r >> 8), int32(g >> 8), int32(bl >> 8)}
+			}
+
+			var fg, bg rgb
+			var glyph string
+			switch opts.Mode {
+			case BLOCKS_QUADRANT, BLOCKS_SEXTANT:
+				var mask int
+				mask, fg, bg = bestSplit(cell[:gx*gy])
+				glyph = blockGlyph(opts.Mode, mask)
+			default:
+				fg, bg, glyph = cell[0], cell[1], "▀"
+			}
+
+			tmp = append(tmp[:0], "\x1b["...)
+			tmp = appendSGRColor(tmp, fg, depth, false)
+			tmp = append(tmp, ';')
+			tmp = appendSGRColor(tmp, bg, depth, true)
+			tmp = append(tmp, 'm')
+			tmp = append(tmp, glyph...)
+
+			if _, E := bw.Write(tmp); E != nil {
+				return E
+			}
+		}
+
+		if _, E := bw.WriteString("\x1b[0m\n"); E != nil {
+			return E
+		}
+	}
+
+	return bw.Flush()
+}
+
+type rgb struct{ r, g, b int32 }
+
+func (c rgb) dist(o rgb) int32 {
+	dr, dg, db := c.r-o.r, c.g-o.g, c.b-o.b
+	return dr*dr + dg*dg + db*db
+}
+
+/*
+Splits a cell's pixels into foreground (set bits of `mask`) &
+background, by the means of each, with the least squared error.  Pixel
+0 is always foreground, so the blank glyph is never needed.
+*/
+func bestSplit(px []rgb) (mask int, fg, bg rgb) {
+
+	n := len(px)
+	best := int32(-1)
+
+	for m := 1; m < (1 << n); m += 2 {
+
+		var sf, sb [3]int32
+		var nf, nb int32
+		for ix, c := range px {
+			if m&(1<<ix) != 0 {
+				sf[0], sf[1], sf[2], nf = sf[0]+c.r, sf[1]+c.g, sf[2]+c.b, nf+1
+			} else {
+				sb[0], sb[1], sb[2], nb = sb[0]+c.r, sb[1]+c.g, sb[2]+c.b, nb+1
+			}
+		}
+
+		f := rgb{sf[0] / nf, sf[1] / nf, sf[2] / nf}
+		b := f
+		if nb > 0 {
+			b = rgb{sb[0] / nb, sb[1] / nb, sb[2] / nb}
+		}
+
+		var e int32
+		for ix, c := range px {
+			if m&(1<<ix) != 0 {
+				e += c.dist(f)
+			} else {
+				e += c.dist(b)
+			}
+		}
+
+		if (best < 0) || (e < best) {
+			best, mask, fg, bg = e, m, f, b
+		}
+	}
+
+	return
+}
+
+// quadrants by mask: 1 upper left, 2 upper right, 4 lower left, 8 lower right
+var quadrantGlyphs = []string{
+	" ", "▘", "▝", "▀", "▖", "▌", "▞", "▛",
+	"▗", "▚", "▐", "▜", "▄", "▙", "▟", "█",
+}
+
+/*
+Glyph for a foreground mask, row by row from the top left.  Sextants
+(U+1FB00-U+1FB3B) skip the patterns older blocks already cover: blank,
+left half, right half & full.
+*/
+func blockGlyph(m BlockMode, mask int) string {
+
+	if m == BLOCKS_QUADRANT {
+		return quadrantGlyphs[mask]
+	}
+
+	switch mask {
+	case 0:
+		return " "
+	case 21:
+		return "▌"
+	case 42:
+		return "▐"
+	case 63:
+		return "█"
+	}
+
+	r := rune(0x1FB00 + mask - 1)
+	if mask > 42 {
+		r -= 2
+	} else if mask > 21 {
+		r--
+	}
+
+	return string(r)
+}
+
+// ANSI colors 0-15, as xterm draws them
+var ansi16 = []rgb{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// levels of the 6x6x6 color cube in the 256-color palette
+var cubeLevels = []int32{0, 95, 135, 175, 215, 255}
+
+func nearestCubeLevel(v int32) int {
+	ix := 0
+	for jx, l := range cubeLevels {
+		if d, dBest := l-v, cubeLevels[ix]-v; d*d < dBest*dBest {
+			ix = jx
+		}
+	}
+	return ix
+}
+
+// nearest of the 256-color palette's cube & gray ramp (16-255)
+func nearest256(c rgb) int {
+
+	ri, gi, bi := nearestCubeLevel(c.r), nearestCubeLevel(c.g), nearestCubeLevel(c.b)
+	cube := rgb{cubeLevels[ri], cubeLevels[gi], cubeLevels[bi]}
+	ixCube := 16 + 36*ri + 6*gi + bi
+
+	// GRAYS 232-255: 8, 18, ..., 238
+	avg := (c.r + c.g + c.b) / 3
+	gi = int((avg - 3) / 10)
+	if gi < 0 {
+		gi = 0
+	} else if gi > 23 {
+		gi = 23
+	}
+	gv := int32(8 + 10*gi)
+
+	if c.dist(rgb{gv, gv, gv}) < c.dist(cube) {
+		return 232 + gi
+	}
+
+	return ixCube
+}
+
+func nearest16(c rgb) int {
+	ix := 0
+	for jx, v := range ansi16 {
+		if c.dist(v) < c.dist(ansi16[ix]) {
+			ix = jx
+		}
+	}
+	return ix
+}
+
+// SGR parameters for a foreground or background color
+func appendSGRColor(tmp []byte, c rgb, depth ColorDepth, bBg bool) []byte {
+
+	switch depth {
+	case COLOR_256:
+
+		if bBg {
+			tmp = append(tmp, "48;5;"...)
+		} else {
+			tmp = append(tmp, "38;5;"...)
+		}
+		return strconv.AppendInt(tmp, int64(nearest256(c)), 10)
+
+	case COLOR_16:
+
+		ix := nearest16(c)
+		base := 30
+		if ix >= 8 {
+			base, ix = 90, ix-8
+		}
+		if bBg {
+			base += 10
+		}
+		return strconv.AppendInt(tmp, int64(base+ix), 10)
+	}
+
+	if bBg {
+		tmp = append(tmp, "48;2;"...)
+	} else {
+		tmp = append(tmp, "38;2;"...)
+	}
+	return appendRGB(tmp, uint32(c.r), uint32(c.g), uint32(c.b))
+}
+
+func appendRGB(tmp []byte, r, g, b uint32) []byte {
+	tmp = strconv.AppendUint(tmp, uint64(r), 10)
+	tmp = append(tmp, ';')
+	tmp = strconv.AppendUint(tmp, uint64(g), 10)
+	tmp = append(tmp, ';')
+	return strconv.AppendUint(tmp, uint64(b), 10)
+}
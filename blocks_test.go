@@ -0,0 +1,108 @@
+package rasterm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestBlocksWriteImage(pT *testing.T) {
+
+	clearTermEnv(pT)
+
+	// 2 x 2: RED OVER BLUE, LEFT; WHITE, RIGHT
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	img.Set(0, 1, color.RGBA{0, 0, 255, 255})
+	img.Set(1, 0, color.White)
+	img.Set(1, 1, color.White)
+
+	type tcase struct {
+		opts BlocksOpts
+		exp  string
+	}
+
+	sTests := []tcase{
+		{
+			BlocksOpts{Colors: COLOR_TRUE},
+			"\x1b[38;2;255;0;0;48;2;0;0;255m▀\x1b[38;2;255;255;255;48;2;255;255;255m▀\x1b[0m\n",
+		},
+		{
+			BlocksOpts{Colors: COLOR_256},
+			"\x1b[38;5;196;48;5;21m▀\x1b[38;5;231;48;5;231m▀\x1b[0m\n",
+		},
+		{
+			BlocksOpts{Colors: COLOR_16},
+			"\x1b[91;44m▀\x1b[97;107m▀\x1b[0m\n",
+		},
+
+		// ONE CELL, 2x2 PIXELS: PURPLE LEFT HALF BEATS RED UPPER LEFT
+		{
+			BlocksOpts{Cols: 1, Rows: 1, Mode: BLOCKS_QUADRANT, Colors: COLOR_TRUE, Resampler: RESAMPLE_NEAREST},
+			"\x1b[38;2;127;0;127;48;2;255;255;255m▌\x1b[0m\n",
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	for _, t := range sTests {
+		buf.Reset()
+		if E := BlocksWriteImage(buf, img, t.opts); E != nil {
+			pT.Fatal(E)
+		}
+		if s := buf.String(); s != t.exp {
+			pT.Errorf("%+v:\nexpected %q\n     got %q", t.opts, t.exp, s)
+		}
+	}
+
+	pT.Setenv("COLORTERM", "truecolor")
+	if EnvColorDepth() != COLOR_TRUE {
+		pT.Error("expected truecolor")
+	}
+	pT.Setenv("COLORTERM", "")
+	pT.Setenv("TERM", "xterm-256color")
+	if EnvColorDepth() != COLOR_256 {
+		pT.Error("expected 256 colors")
+	}
+	pT.Setenv("TERM", "vt100")
+	if EnvColorDepth() != COLOR_16 {
+		pT.Error("expected 16 colors")
+	}
+}
+
+func TestBlockGlyphs(pT *testing.T) {
+
+	// 2x3 CHECKERBOARD: TOP LEFT, MIDDLE RIGHT, BOTTOM LEFT
+	px := []rgb{{255, 255, 255}, {0, 0, 0}, {0, 0, 0}, {255, 255, 255}, {255, 255, 255}, {0, 0, 0}}
+	mask, fg, bg := bestSplit(px)
+	if (mask != 1+8+16) || (fg != rgb{255, 255, 255}) || (bg != rgb{}) {
+		pT.Errorf("unexpected split: %d %v %v", mask, fg, bg)
+	}
+
+	sTests := map[int]string{
+		1:  "\U0001FB00",
+		20: "\U0001FB13",
+		21: "▌",
+		22: "\U0001FB14",
+		42: "▐",
+		43: "\U0001FB28",
+		62: "\U0001FB3B",
+		63: "█",
+	}
+	for mask, exp := range sTests {
+		if s := blockGlyph(BLOCKS_SEXTANT, mask); s != exp {
+			pT.Errorf("sextant %d: expected %q, got %q", mask, exp, s)
+		}
+	}
+
+	for mask := 0; mask < 64; mask++ {
+		if s := blockGlyph(BLOCKS_SEXTANT, mask); strings.Count(s, "") != 2 {
+			pT.Errorf("sextant %d: %q", mask, s)
+		}
+	}
+
+	if blockGlyph(BLOCKS_QUADRANT, 9) != "▚" {
+		pT.Error("quadrant 9")
+	}
+}
@@ -0,0 +1,214 @@
+package rasterm
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+NOTE: the calling program MUST be connected to an actual terminal for this to work
+
+Queries the terminal's default foreground color with OSC 10:
+
+	OSC 10 ; ? ST  ⇒  OSC 10 ; rgb:rrrr/gggg/bbbb ST
+*/
+func QueryForeground() (color.Color, error) {
+	return StdTermIO().QueryForeground(context.Background())
+}
+
+/*
+NOTE: the calling program MUST be connected to an actual terminal for this to work
+
+Queries the terminal's default background color with OSC 11:
+
+	OSC 11 ; ? ST  ⇒  OSC 11 ; rgb:rrrr/gggg/bbbb ST
+*/
+func QueryBackground() (color.Color, error) {
+	return StdTermIO().QueryBackground(context.Background())
+}
+
+/*
+NOTE: the calling program MUST be connected to an actual terminal for this to work
+
+Queries entry `ix` (0-255) of the terminal's color palette with OSC 4:
+
+	OSC 4 ; ix ; ? ST  ⇒  OSC 4 ; ix ; rgb:rrrr/gggg/bbbb ST
+*/
+func QueryPaletteColor(ix int) (color.Color, error) {
+	return StdTermIO().QueryPaletteColor(context.Background(), ix)
+}
+
+// See QueryForeground.
+func (t *TermIO) QueryForeground(ctx context.Context) (color.Color, error) {
+	return t.queryColor(ctx, PROBE_FOREGROUND)
+}
+
+// See QueryBackground.
+func (t *TermIO) QueryBackground(ctx context.Context) (color.Color, error) {
+	return t.queryColor(ctx, PROBE_BACKGROUND)
+}
+
+// See QueryPaletteColor.
+func (t *TermIO) QueryPaletteColor(ctx context.Context, ix int) (color.Color, error) {
+
+	sIx := strconv.Itoa(ix)
+	q := ProbeQuery{
+		Name:    "palette_" + sIx,
+		Request: "\x1b]4;" + sIx + ";?\x1b\\",
+		Reply:   regexp.MustCompile(`\x1b\]4;` + sIx + `;([^\x07\x1b]*)(?:\x07|\x1b\\)`),
+	}
+
+	return t.queryColor(ctx, q)
+}
+
+// probes `q`, whose reply's first submatch holds the color
+func (t *TermIO) queryColor(ctx context.Context, q ProbeQuery) (color.Color, error) {
+
+	R, E := t.Probe(ctx, ProbeOpts{Queries: []ProbeQuery{q}})
+	if E != nil {
+		return nil, E
+	}
+
+	if c, bOK := parseOSCColor(R.Replies[q.Name], q.Reply); bOK {
+		return c, nil
+	}
+
+	return nil, E_BAD_RESPONSE
+}
+
+func parseOSCColor(rsp []byte, rx *regexp.Regexp) (color.Color, bool) {
+
+	m := rx.FindSubmatch(rsp)
+	if len(m) < 2 {
+		return nil, false
+	}
+
+	return parseXColor(string(m[1]))
+}
+
+/*
+Parses an X11 color specification, as found in OSC color replies:
+
+	rgb:r/g/b     1-4 hex digits per component
+	#rgb          1-4 hex digits per component, same count for each
+
+Most terminals reply with `rgb:rrrr/gggg/bbbb`.
+*/
+func parseXColor(spec string) (color.Color, bool) {
+
+	spec = strings.TrimSpace(spec)
+
+	var parts []string
+	if strings.HasPrefix(spec, "rgb:") {
+
+		parts = strings.Split(spec[4:], "/")
+
+	} else if s := strings.TrimPrefix(spec, "#"); (len(s) < len(spec)) && (len(s) > 0) && (len(s)%3 == 0) {
+
+		n := len(s) / 3
+		parts = []string{s[:n], s[n : 2*n], s[2*n:]}
+	}
+
+	if len(parts) != 3 {
+		return nil, false
+	}
+
+	var c [3]uint16
+	for ix, v := range parts {
+
+		if (len(v) < 1) || (len(v) > 4) {
+			return nil, false
+		}
+
+		n, E := strconv.ParseUint(v, 16, 16)
+		if E != nil {
+			return nil, false
+		}
+
+		// SCALE TO 16 BITS: "f" => "ffff", "80" => "8080"
+		nMax := uint64(1)<<(4*len(v)) - 1
+		c[ix] = uint16(n * 0xFFFF / nMax)
+	}
+
+	return color.RGBA64{c[0], c[1], c[2], 0xFFFF}, true
+}
+
+/*
+Classifies a color as dark by its luminance, e.g. to choose icon
+variants for a terminal background:
+
+	bg, E := QueryBackground()
+	bDark := (E != nil) || IsDarkColor(bg)
+*/
+func IsDarkColor(c color.Color) bool {
+
+	r, g, b, _ := c.RGBA()
+
+	// REC. 709 LUMA
+	Y := 0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)
+	return Y < 0.5*0xFFFF
+}
+
+/*
+NOTE: the calling program MUST be connected to an actual terminal for this to work
+
+Reports whether the terminal background is dark.  See IsDarkColor.
+*/
+func IsDarkBackground() (bool, error) {
+	return StdTermIO().IsDarkBackground(context.Background())
+}
+
+// See IsDarkBackground.
+func (t *TermIO) IsDarkBackground(ctx context.Context) (bool, error) {
+
+	bg, E := t.QueryBackground(ctx)
+	if E != nil {
+		return false, E
+	}
+
+	return IsDarkColor(bg), nil
+}
+
+// matte where neither the caller nor Capabilities give one
+var fallbackMatte color.Color = color.Black
+
+// `c` composited over `matte`, opaque
+func blendMatte(c, matte color.Color) color.Color {
+
+	r, g, b, a := c.RGBA()
+	mr, mg, mb, _ := matte.RGBA()
+
+	// PREMULTIPLIED SOURCE OVER OPAQUE MATTE
+	k := 0xFFFF - a
+	return color.RGBA64{
+		uint16(r + mr*k/0xFFFF),
+		uint16(g + mg*k/0xFFFF),
+		uint16(b + mb*k/0xFFFF),
+		0xFFFF,
+	}
+}
+
+// `img` flattened onto `matte` (black if nil), or `img` itself if
+// already opaque
+func flattenAlpha(img image.Image, matte color.Color) image.Image {
+
+	if o, bOK := img.(interface{ Opaque() bool }); bOK && o.Opaque() {
+		return img
+	}
+
+	if matte == nil {
+		matte = fallbackMatte
+	}
+
+	rect := img.Bounds()
+	flat := image.NewRGBA(rect)
+	draw.Draw(flat, rect, &image.Uniform{matte}, image.Point{}, draw.Src)
+	draw.Draw(flat, rect, img, rect.Min, draw.Over)
+
+	return flat
+}
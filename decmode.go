@@ -0,0 +1,100 @@
+package rasterm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// DEC private modes relevant to image output
+const (
+	// DECSDM, Sixel Display Mode.  In xterm & most emulators: when set,
+	// sixel scrolling is disabled -- images are drawn from the top-left
+	// of the screen and the cursor does not move.  When reset (default),
+	// images are drawn at the cursor, scroll the screen as needed, and
+	// leave the cursor below the image.
+	DECMODE_SIXEL_DISPLAY = 80
+
+	// xterm: when set, the cursor is left to the right of a sixel image
+	// rather than below it.
+	DECMODE_SIXEL_CURSOR_RIGHT = 8452
+
+	// Synchronized output (ESC_SYNC_BEGIN / ESC_SYNC_END).
+	DECMODE_SYNC_OUTPUT = 2026
+)
+
+// DECRPM mode state, per reply to a DECRQM request
+type DecModeState int
+
+const (
+	DECRPM_NOT_RECOGNIZED DecModeState = 0
+	DECRPM_SET            DecModeState = 1
+	DECRPM_RESET          DecModeState = 2
+	DECRPM_PERM_SET       DecModeState = 3
+	DECRPM_PERM_RESET     DecModeState = 4
+)
+
+func (s DecModeState) IsSet() bool {
+	return (s == DECRPM_SET) || (s == DECRPM_PERM_SET)
+}
+
+// Terminal recognizes the mode, whether or not it can be changed.
+func (s DecModeState) IsRecognized() bool {
+	return (s >= DECRPM_SET) && (s <= DECRPM_PERM_RESET)
+}
+
+// Mode is on or can be turned on: set, reset, or permanently set.
+func (s DecModeState) IsAvailable() bool {
+	return (s >= DECRPM_SET) && (s <= DECRPM_PERM_SET)
+}
+
+/*
+NOTE: the calling program MUST be connected to an actual terminal for this to work
+
+Queries the state of DEC private `mode` with DECRQM:
+
+	CSI ? Ps $ p  ⇒  CSI ? Ps ; Pm $ y
+*/
+func QueryPrivateMode(mode int) (DecModeState, error) {
+	return StdTermIO().QueryPrivateMode(context.Background(), mode)
+}
+
+// See QueryPrivateMode.
+func (t *TermIO) QueryPrivateMode(ctx context.Context, mode int) (DecModeState, error) {
+
+	sRq := fmt.Sprintf("\x1b[?%d$p", mode)
+	rsp, E := t.Query(ctx, sRq, TermQueryOpts{Terminator: rxDECRPM})
+	if E != nil {
+		return DECRPM_NOT_RECOGNIZED, E
+	}
+
+	return parseDECRPM(rsp, mode)
+}
+
+var rxDECRPM = regexp.MustCompile(`\x1b\[\?(\d+);(\d+)\$y`)
+
+func parseDECRPM(rsp []byte, mode int) (DecModeState, error) {
+
+	for _, m := range rxDECRPM.FindAllSubmatch(rsp, -1) {
+		if n, _ := strconv.Atoi(string(m[1])); n == mode {
+			st, _ := strconv.Atoi(string(m[2]))
+			return DecModeState(st), nil
+		}
+	}
+
+	return DECRPM_NOT_RECOGNIZED, E_BAD_RESPONSE
+}
+
+// Sets (DECSET) or resets (DECRST) DEC private `mode`.
+func SetPrivateMode(out io.Writer, mode int, bSet bool) error {
+
+	cmd := 'l'
+	if bSet {
+		cmd = 'h'
+	}
+
+	_, E := fmt.Fprintf(out, "\x1b[?%d%c", mode, cmd)
+	return E
+}
@@ -0,0 +1,386 @@
+package rasterm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+/*
+Splits a terminal's input between the application and rasterm queries.
+
+Terminal replies (DA, CPR, XTVERSION, DECRPM, window reports, Kitty
+graphics, OSC 11, OSC 1337, ...) go to the query waiting on them, or
+are discarded if none is.  Everything else, keystrokes, mouse reports,
+pasted text, is read from the InputDemux as an io.Reader.
+
+For use inside a live TUI: the application reads its input here instead
+of from the terminal, and queries through TermIO().  The demux owns
+`src` from NewInputDemux on.
+*/
+type InputDemux struct {
+	src io.Reader
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	input   []byte // for the application
+	inErr   error  // from src, once `input` drains
+	waiting bool   // a query is active
+	replies []byte // for the active query
+	notify  chan struct{}
+
+	query sync.Mutex // one query at a time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Read & queries' error after InputDemux.Close.
+var E_DEMUX_CLOSED = errors.New("INPUT DEMUX CLOSED")
+
+/*
+How long an incomplete escape sequence is held for its remaining bytes
+before passing to the application as input, e.g. a lone ESC keypress.
+While a query is active, incomplete sequences are held until it ends.
+*/
+var DEMUX_ESC_DELAY = 50 * time.Millisecond
+
+// Starts reading `src`, normally the terminal, in raw mode.
+func NewInputDemux(src io.Reader) *InputDemux {
+
+	d := &InputDemux{src: src, notify: make(chan struct{}, 1), done: make(chan struct{})}
+	d.cond = sync.NewCond(&d.mu)
+
+	go d.run()
+	return d
+}
+
+/*
+Stops routing input: Read & queries then fail with E_DEMUX_CLOSED once
+input already routed drains.  `src` isn't closed, and a Read on it
+already underway is left to finish, its data discarded.
+*/
+func (d *InputDemux) Close() error {
+
+	d.closeOnce.Do(func() {
+		close(d.done)
+		d.fail(E_DEMUX_CLOSED)
+	})
+
+	return nil
+}
+
+// ends input with E, unless it already has an error
+func (d *InputDemux) fail(E error) {
+
+	d.mu.Lock()
+	if d.inErr == nil {
+		d.inErr = E
+	}
+	d.mu.Unlock()
+
+	d.cond.Broadcast()
+	d.wake()
+}
+
+// Reads application input, with terminal replies removed.
+func (d *InputDemux) Read(p []byte) (int, error) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for (len(d.input) == 0) && (d.inErr == nil) {
+		d.cond.Wait()
+	}
+
+	if len(d.input) == 0 {
+		return 0, d.inErr
+	}
+
+	n := copy(p, d.input)
+	d.input = d.input[n:]
+	return n, nil
+}
+
+/*
+TermIO writing requests to `out` and reading replies from the demux.
+
+The application manages the terminal mode, so there's no RawModer.
+Concurrent queries are serialized.
+*/
+func (d *InputDemux) TermIO(out io.Writer) *TermIO {
+	return &TermIO{Out: out, demux: d}
+}
+
+// starts routing replies to a query; stale replies are dropped
+func (d *InputDemux) begin() {
+
+	d.query.Lock()
+
+	d.mu.Lock()
+	d.waiting, d.replies = true, nil
+	d.mu.Unlock()
+}
+
+func (d *InputDemux) end() {
+
+	d.mu.Lock()
+	d.waiting, d.replies = false, nil
+	d.mu.Unlock()
+
+	d.query.Unlock()
+}
+
+// reads replies arriving within `slice`; (0, nil) if none did
+func (d *InputDemux) readReply(ctx context.Context, buf []byte, slice time.Duration) (int, error) {
+
+	tmr := time.NewTimer(slice)
+	defer tmr.Stop()
+
+	for {
+		d.mu.Lock()
+		n := copy(buf, d.replies)
+		d.replies = d.replies[n:]
+		E := d.inErr
+		d.mu.Unlock()
+
+		if n > 0 {
+			return n, nil
+		}
+		if E != nil {
+			return 0, E
+		}
+
+		select {
+		case <-d.notify:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-tmr.C:
+			return 0, nil
+		}
+	}
+}
+
+type demuxChunk struct {
+	buf []byte
+	E   error
+}
+
+func (d *InputDemux) run() {
+
+	chunks := make(chan demuxChunk)
+	go func() {
+		for {
+			tmp := make([]byte, 1024)
+			n, E := d.src.Read(tmp)
+			select {
+			case chunks <- demuxChunk{tmp[:n], E}:
+			case <-d.done:
+				return
+			}
+			if E != nil {
+				return
+			}
+		}
+	}()
+
+	tmr := time.NewTimer(DEMUX_ESC_DELAY)
+	tmr.Stop()
+
+	defer tmr.Stop()
+
+	// STOP & DRAIN FIRST: A STALE FIRE WOULD FLUSH THE NEXT PARTIAL EARLY
+	reset := func() {
+		if !tmr.Stop() {
+			select {
+			case <-tmr.C:
+			default:
+			}
+		}
+		tmr.Reset(DEMUX_ESC_DELAY)
+	}
+
+	var partial []byte
+	for {
+		select {
+		case <-d.done:
+			return
+
+		case c := <-chunks:
+
+			partial = d.feed(append(partial, c.buf...), c.E != nil)
+
+			if c.E != nil {
+				d.fail(c.E)
+				return
+			}
+
+			if len(partial) > 0 {
+				reset()
+			}
+
+		case <-tmr.C:
+
+			d.mu.Lock()
+			bWaiting := d.waiting
+			d.mu.Unlock()
+
+			// HOLD FOR THE QUERY, OTHERWISE IT'S INPUT
+			if bWaiting {
+				reset()
+			} else {
+				partial = d.feed(partial, true)
+			}
+		}
+	}
+}
+
+func (d *InputDemux) wake() {
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+// routes complete sequences of `buf`, returns the incomplete tail unless
+// flushing it to input
+func (d *InputDemux) feed(buf []byte, bFlush bool) []byte {
+
+	d.mu.Lock()
+
+	bReplies := false
+	for len(buf) > 0 {
+
+		n, kind := demuxNext(buf, d.waiting)
+		if kind == demuxPartial {
+			if !bFlush {
+				break
+			}
+			n, kind = len(buf), demuxInput
+		}
+
+		switch {
+		case kind == demuxInput:
+			d.input = append(d.input, buf[:n]...)
+		case d.waiting:
+			d.replies = append(d.replies, buf[:n]...)
+			bReplies = true
+		}
+
+		buf = buf[n:]
+	}
+
+	bInput := len(d.input) > 0
+	d.mu.Unlock()
+
+	if bInput {
+		d.cond.Broadcast()
+	}
+	if bReplies {
+		d.wake()
+	}
+
+	// NEXT CHUNK APPENDS, SO DON'T ALIAS IT
+	return append([]byte(nil), buf...)
+}
+
+const (
+	demuxInput = iota
+	demuxReply
+	demuxPartial
+)
+
+/*
+Classifies the sequence at the start of `b` and returns its length.
+
+Keyboards only produce CSI sequences, and none with private parameters
+(`?`, `>`, `=`) or ending in `t`, `n` or `$y`.  A bare CPR (`CSI r;c R`)
+is also modified F3, so it's a reply only while `bWaiting`.  DCS, OSC,
+APC & PM strings are always replies, unless a control byte cuts them
+short (e.g. Alt+P, Enter).
+*/
+func demuxNext(b []byte, bWaiting bool) (int, int) {
+
+	// TEXT UP TO NEXT ESC
+	if b[0] != 0x1b {
+		for ix := 1; ix < len(b); ix++ {
+			if b[ix] == 0x1b {
+				return ix, demuxInput
+			}
+		}
+		return len(b), demuxInput
+	}
+
+	if len(b) < 2 {
+		return 1, demuxPartial
+	}
+
+	switch b[1] {
+	case '[':
+		return demuxCSI(b, bWaiting)
+	case 'P', ']', '_', '^':
+		return demuxString(b)
+	}
+
+	return 1, demuxInput
+}
+
+func demuxCSI(b []byte, bWaiting bool) (int, int) {
+
+	ix := 2
+	for (ix < len(b)) && (b[ix] >= 0x30) && (b[ix] <= 0x3f) {
+		ix++
+	}
+	param := b[2:ix]
+
+	for (ix < len(b)) && (b[ix] >= 0x20) && (b[ix] <= 0x2f) {
+		ix++
+	}
+	inter := b[2+len(param) : ix]
+
+	if ix >= len(b) {
+		return len(b), demuxPartial
+	}
+
+	final := b[ix]
+	if (final < 0x40) || (final > 0x7e) {
+		return 1, demuxInput
+	}
+	n := ix + 1
+
+	bPrivate := (len(param) > 0) && ((param[0] == '?') || (param[0] == '>') || (param[0] == '='))
+	switch {
+	case bPrivate,
+		final == 't',
+		final == 'n',
+		(final == 'y') && (string(inter) == "$"),
+		(final == 'R') && bWaiting:
+		return n, demuxReply
+	}
+
+	return n, demuxInput
+}
+
+func demuxString(b []byte) (int, int) {
+
+	for ix := 2; ix < len(b); ix++ {
+		switch c := b[ix]; {
+		case c == 0x07:
+			return ix + 1, demuxReply
+		case c == 0x1b:
+			if ix+1 >= len(b) {
+				return len(b), demuxPartial
+			}
+			if b[ix+1] == '\\' {
+				return ix + 2, demuxReply
+			}
+			return 1, demuxInput
+		case c < 0x20:
+			return 1, demuxInput
+		}
+	}
+
+	return len(b), demuxPartial
+}
@@ -0,0 +1,155 @@
+package rasterm
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reads from the demux until `n` bytes of input arrive
+func readInput(pT *testing.T, d *InputDemux, n int) string {
+
+	done := make(chan string, 1)
+	go func() {
+		buf := make([]byte, n)
+		nRead, _ := io.ReadFull(d, buf)
+		done <- string(buf[:nRead])
+	}()
+
+	select {
+	case s := <-done:
+		return s
+	case <-time.After(2 * time.Second):
+		pT.Fatalf("timed out reading %d bytes of input", n)
+		return ""
+	}
+}
+
+// fake terminal answering each write with the next script entry
+type scriptWri struct {
+	w      io.Writer
+	script [][]string
+}
+
+func (s *scriptWri) Write(p []byte) (int, error) {
+
+	if len(s.script) > 0 {
+		parts := s.script[0]
+		s.script = s.script[1:]
+		go func() {
+			for ix, v := range parts {
+				if ix > 0 {
+					time.Sleep(2 * DEMUX_ESC_DELAY)
+				}
+				io.WriteString(s.w, v)
+			}
+		}()
+	}
+
+	return len(p), nil
+}
+
+func TestInputDemuxPassthrough(pT *testing.T) {
+
+	r, w := io.Pipe()
+	pT.Cleanup(func() { w.Close() })
+	d := NewInputDemux(r)
+
+	// UNSOLICITED REPLIES DROPPED, KEYS KEPT (INCL. MODIFIED F3, WHICH LOOKS LIKE CPR)
+	io.WriteString(w, "ab\x1b[A\x1b_Gi=1;OK\x1b\\\x1b[?62;4c")
+	io.WriteString(w, "c\x1b[1;2R\x1b[<0;3;4M")
+	want := "ab\x1b[Ac\x1b[1;2R\x1b[<0;3;4M"
+	if s := readInput(pT, d, len(want)); s != want {
+		pT.Errorf("got %q, want %q", s, want)
+	}
+
+	// LONE ESC, & ALT+P FOLLOWED BY ENTER
+	io.WriteString(w, "\x1b")
+	if s := readInput(pT, d, 1); s != "\x1b" {
+		pT.Errorf("lone ESC: got %q", s)
+	}
+
+	io.WriteString(w, "\x1bP\r")
+	if s := readInput(pT, d, 3); s != "\x1bP\r" {
+		pT.Errorf("Alt+P: got %q", s)
+	}
+}
+
+func TestInputDemuxQuery(pT *testing.T) {
+
+	r, w := io.Pipe()
+	pT.Cleanup(func() { w.Close() })
+	d := NewInputDemux(r)
+
+	tio := d.TermIO(&scriptWri{w: w, script: [][]string{
+		{"x\x1b[?62;4;22cy"},
+		{"\x1bP>|Wez", "Term 20240203\x1b\\z"},
+	}})
+	tio.Timeout = time.Second
+	ctx := context.Background()
+
+	if bSix, E := tio.IsSixelCapable(ctx); !bSix || (E != nil) {
+		pT.Errorf("sixel %v, %v", bSix, E)
+	}
+
+	// REPLY SPLIT FOR LONGER THAN DEMUX_ESC_DELAY
+	if s, E := tio.RequestXTVersion(ctx); (E != nil) || !strings.Contains(s, "WezTerm") {
+		pT.Errorf("XTVERSION %q, %v", s, E)
+	}
+
+	if s := readInput(pT, d, 3); s != "xyz" {
+		pT.Errorf("input: got %q", s)
+	}
+}
+
+func TestInputDemuxClose(pT *testing.T) {
+
+	r, w := io.Pipe()
+	pT.Cleanup(func() { w.Close() })
+	d := NewInputDemux(r)
+
+	io.WriteString(w, "a")
+	if s := readInput(pT, d, 1); s != "a" {
+		pT.Errorf("input: got %q", s)
+	}
+
+	// BLOCKED READ WAKES
+	done := make(chan error, 1)
+	go func() {
+		_, E := d.Read(make([]byte, 1))
+		done <- E
+	}()
+
+	d.Close()
+	d.Close()
+
+	select {
+	case E := <-done:
+		if E != E_DEMUX_CLOSED {
+			pT.Errorf("read: got %v", E)
+		}
+	case <-time.After(2 * time.Second):
+		pT.Fatal("read not woken by Close")
+	}
+
+	tio := d.TermIO(io.Discard)
+	tio.Timeout = time.Second
+	if _, E := tio.RequestXTVersion(context.Background()); E == nil {
+		pT.Error("expected query to fail after Close")
+	}
+
+	// THE READ UNDERWAY FINISHES, THEN THE READER STOPS
+	wrote := make(chan struct{})
+	go func() {
+		io.WriteString(w, "b")
+		close(wrote)
+	}()
+
+	select {
+	case <-wrote:
+	case <-time.After(2 * time.Second):
+		pT.Fatal("reader stuck after Close")
+	}
+}
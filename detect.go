@@ -0,0 +1,312 @@
+package rasterm
+
+import (
+	"context"
+	"image/color"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// Terminal image protocol identifier
+type Protocol string
+
+const (
+	PROTO_NONE  Protocol = "none"
+	PROTO_KITTY Protocol = "kitty"
+	PROTO_ITERM Protocol = "iterm"
+	PROTO_SIXEL Protocol = "sixel"
+
+	// Unicode block characters in truecolor text, for when nothing
+	// better is available.  Only chosen by RASTERM_PROTOCOL.
+	PROTO_BLOCKS Protocol = "blocks"
+)
+
+// Image protocols in order of preference
+var protoPreference = []Protocol{PROTO_KITTY, PROTO_ITERM, PROTO_SIXEL}
+
+/*
+Terminal capability report, see Detect.
+
+Numeric fields are 0 when unknown.  `Source` records how each fact was
+determined, keyed by fact name ("kitty", "iterm", "sixel", "term",
+"multiplexer", "sixel_colors", "sixel_geometry", "sync_output",
+"foreground", "background"), with
+values like "env:TERM_PROGRAM" or "query:DA1".  Geometry has its own
+Source.
+*/
+type Capabilities struct {
+	Protocols   []Protocol // supported protocols, most preferred first
+	Recommended Protocol   // Protocols[0], or PROTO_NONE
+
+	TermName    string
+	TermVersion string
+	Multiplexer string // "tmux", "screen", or ""
+
+	Geometry TermGeometry // cell & text area sizes
+
+	SixelMaxColors int // XTSMGRAPHICS color registers
+	SixelMaxWidth  int // XTSMGRAPHICS max sixel geometry
+	SixelMaxHeight int
+
+	DA1 []int // primary device attributes
+
+	SyncOutput bool // synchronized output (DECMODE_SYNC_OUTPUT) supported
+
+	Foreground color.Color // default colors, nil if unknown
+	Background color.Color
+
+	Source map[string]string
+}
+
+func (c *Capabilities) Supports(p Protocol) bool {
+	for _, v := range c.Protocols {
+		if v == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Capabilities) addProtocol(p Protocol, src string) {
+	if !c.Supports(p) {
+		c.Protocols = append(c.Protocols, p)
+		c.Source[string(p)] = src
+	}
+}
+
+type DetectOpts struct {
+	// Terminal to query.  Defaults to StdTermIO().  Set IO.Timeout
+	// for slow links.
+	IO *TermIO
+
+	// Environment checks only, no terminal queries.  Geometry still
+	// comes from TIOCGWINSZ & RASTERM_CELL_SIZE.
+	NoQuery bool
+}
+
+/*
+Runs environment checks & terminal queries once, and returns everything
+learned about the terminal.  RASTERM_* variables override what's
+detected, see Overrides.
+
+Queries are skipped when the terminal isn't a TTY; this isn't an error.
+The error is non-nil only if `ctx` ends before detection completes.
+*/
+func Detect(ctx context.Context, opts DetectOpts) (*Capabilities, error) {
+
+	if opts.IO == nil {
+		opts.IO = StdTermIO()
+	}
+
+	C := &Capabilities{Source: make(map[string]string)}
+	V := GetEnvIdentifiers()
+
+	// MULTIPLEXER
+	if IsTmux() {
+		C.Multiplexer, C.Source["multiplexer"] = "tmux", "env:TMUX"
+	} else if len(os.Getenv("STY")) > 0 {
+		C.Multiplexer, C.Source["multiplexer"] = "screen", "env:STY"
+	} else if IsScreen() {
+		C.Multiplexer, C.Source["multiplexer"] = "screen", "env:TERM"
+	}
+
+	// TERMINAL NAME & VERSION, REFINED BY QUERIES BELOW
+	if id := termFromEnv(V); id.Name != "" {
+		C.TermName, C.TermVersion, C.Source["term"] = id.Name, id.Version, id.Source
+	} else if len(V["TERM"]) > 0 {
+		C.TermName, C.Source["term"] = V["TERM"], "env:TERM"
+	}
+
+	// OUTER TERMINAL, AS KNOWN TO TMUX
+	if C.Multiplexer == "tmux" {
+		if tc, E := cachedTmuxClient(); E == nil {
+			if id := tc.Identify(); id.Name != "" {
+				C.TermName, C.TermVersion, C.Source["term"] = id.Name, id.Version, id.Source
+			}
+		}
+	}
+
+	// ENVIRONMENT-BASED PROTOCOLS
+	if K := kittyEnvKey(V); K != "" {
+		C.addProtocol(PROTO_KITTY, "env:"+K)
+	}
+	if K := itermEnvKey(V); K != "" {
+		C.addProtocol(PROTO_ITERM, "env:"+K)
+	}
+
+	if !opts.NoQuery {
+		if E := detectQuery(ctx, opts, C); E != nil {
+			return C, E
+		}
+	} else {
+		C.Geometry = opts.IO.geometryFromProbe(nil)
+	}
+
+	// PROTOCOLS OF KNOWN TERMINALS, SIXEL CONFIRMED BY DA1 IF ANSWERED
+	for _, p := range termProtocols[C.TermName] {
+		if (p == PROTO_SIXEL) && (C.DA1 != nil) && !isSixelDA1(C.DA1) {
+			continue
+		}
+		C.addProtocol(p, "termdb:"+C.TermName)
+	}
+
+	// USER SAYS OTHERWISE
+	if p, bOK := protocolOverride(V); bOK {
+		for _, v := range C.Protocols {
+			delete(C.Source, string(v))
+		}
+		C.Protocols = nil
+		if p != PROTO_NONE {
+			C.addProtocol(p, "env:"+ENV_PROTOCOL)
+		}
+	}
+
+	sortProtocols(C.Protocols)
+	C.Recommended = PROTO_NONE
+	if len(C.Protocols) > 0 {
+		C.Recommended = C.Protocols[0]
+	}
+
+	return C, nil
+}
+
+// active queries, batched into one Probe; unanswered queries just leave
+// their facts unknown
+func detectQuery(ctx context.Context, opts DetectOpts, C *Capabilities) error {
+
+	// INSIDE TMUX, ASK THE OUTER TERMINAL IF TMUX WILL PASS THE QUERIES
+	bWrap := wantTmuxPassthrough()
+
+	R, E := opts.IO.Probe(ctx, ProbeOpts{Queries: PROBE_ALL, TmuxPassthrough: bWrap})
+	if E != nil {
+
+		// NO TTY, OR NOT EVEN DA1 ANSWERED: TIOCGWINSZ MAY STILL WORK
+		C.Geometry = opts.IO.geometryFromProbe(nil)
+		return ctx.Err()
+	}
+
+	// XTVERSION, THEN SECONDARY DA: TERMINAL IDENTITY
+	id := TermID{}
+	if s, bOK := parseXTVERSION(R.Replies[PROBE_XTVERSION.Name]); bOK {
+		id = termFromXTVersion(s)
+	}
+
+	if (id.Name == "") && R.Has(PROBE_DA2.Name) {
+		id = termFromDA2(parseDA2(R.Replies[PROBE_DA2.Name]))
+	}
+
+	// TMUX ANSWERING FOR ITSELF SAYS NOTHING ABOUT THE OUTER TERMINAL
+	if (id.Name != "") && !((id.Name == "tmux") && (C.TermName != "")) {
+		C.TermName, C.TermVersion, C.Source["term"] = id.Name, id.Version, id.Source
+	}
+
+	// KITTY GRAPHICS QUERY
+	if m := rxKittyReply.FindSubmatch(R.Replies[PROBE_KITTY.Name]); (m != nil) && (string(m[1]) == "OK") {
+		C.addProtocol(PROTO_KITTY, "query:kitty")
+	}
+
+	// PRIMARY DA: SIXEL SUPPORT
+	C.DA1 = R.DA1
+	if isSixelDA1(C.DA1) {
+		C.addProtocol(PROTO_SIXEL, "query:DA1")
+	}
+
+	// XTSMGRAPHICS: SIXEL COLOR REGISTERS & MAX GEOMETRY
+	if v := parseXTSMGRAPHICS(R.Replies[PROBE_SIXEL_COLORS.Name], 1); len(v) > 0 {
+		C.SixelMaxColors, C.Source["sixel_colors"] = v[0], "query:XTSMGRAPHICS"
+	}
+
+	if v := parseXTSMGRAPHICS(R.Replies[PROBE_SIXEL_GEOMETRY.Name], 2); len(v) > 1 {
+		C.SixelMaxWidth, C.SixelMaxHeight = v[0], v[1]
+		C.Source["sixel_geometry"] = "query:XTSMGRAPHICS"
+	}
+
+	// DECRQM: SYNCHRONIZED OUTPUT, UNLESS PERMANENTLY RESET
+	if st, E := parseDECRPM(R.Replies[PROBE_SYNC_OUTPUT.Name], DECMODE_SYNC_OUTPUT); (E == nil) && st.IsAvailable() {
+		C.SyncOutput, C.Source["sync_output"] = true, "query:DECRQM"
+	}
+
+	// OSC 10 / 11: DEFAULT COLORS
+	if c, bOK := parseOSCColor(R.Replies[PROBE_FOREGROUND.Name], rxOSC10); bOK {
+		C.Foreground, C.Source["foreground"] = c, "query:OSC 10"
+	}
+
+	if c, bOK := parseOSCColor(R.Replies[PROBE_BACKGROUND.Name], rxOSC11); bOK {
+		C.Background, C.Source["background"] = c, "query:OSC 11"
+	}
+
+	// XTWINOPS / TIOCGWINSZ: CELL & TEXT AREA SIZES
+	C.Geometry = opts.IO.geometryFromProbe(R)
+
+	return ctx.Err()
+}
+
+func sortProtocols(sP []Protocol) {
+
+	ix := 0
+	for _, p := range protoPreference {
+		for jx := ix; jx < len(sP); jx++ {
+			if sP[jx] == p {
+				sP[ix], sP[jx] = sP[jx], sP[ix]
+				ix++
+				break
+			}
+		}
+	}
+}
+
+func parseNumbers(text []byte) []int {
+
+	t2 := rxNumber.FindAll(text, -1)
+	sN := make([]int, len(t2))
+	for ix, v := range t2 {
+		sN[ix], _ = strconv.Atoi(string(v))
+	}
+
+	return sN
+}
+
+var rxXTSMGRAPHICS = regexp.MustCompile(`\x1b\[\?(\d+);(\d+);([\d;]*)S`)
+
+/*
+Parses an XTSMGRAPHICS reply for item `item` (1 = color registers,
+2 = sixel geometry):
+
+	CSI ? Pi ; Ps ; Pv S
+
+Returns the Pv values when Ps = 0 (success), nil otherwise.
+*/
+func parseXTSMGRAPHICS(rsp []byte, item int) []int {
+
+	for _, m := range rxXTSMGRAPHICS.FindAllSubmatch(rsp, -1) {
+		if n, _ := strconv.Atoi(string(m[1])); (n == item) && (string(m[2]) == "0") {
+			return parseNumbers(m[3])
+		}
+	}
+
+	return nil
+}
+
+var rxWinOpReport = regexp.MustCompile(`\x1b\[(\d+);(\d+);(\d+)t`)
+
+/*
+Parses an XTWINOPS report:
+
+	CSI code ; a ; b t
+
+For codes 4 (text area pixels), 6 (cell pixels) & 8 (text area cells),
+`a` is the height & `b` is the width.
+*/
+func parseWinOpReport(rsp []byte, code int) (a, b int, bOK bool) {
+
+	for _, m := range rxWinOpReport.FindAllSubmatch(rsp, -1) {
+		if n, _ := strconv.Atoi(string(m[1])); n == code {
+			a, _ = strconv.Atoi(string(m[2]))
+			b, _ = strconv.Atoi(string(m[3]))
+			return a, b, (a > 0) && (b > 0)
+		}
+	}
+
+	return 0, 0, false
+}
@@ -0,0 +1,332 @@
+package rasterm
+
+import (
+	"context"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clears environment identifiers used by detection for the test's duration
+func clearTermEnv(pT *testing.T) {
+	for _, K := range []string{
+		"TERM", "TERM_PROGRAM", "TERM_PROGRAM_VERSION", "LC_TERMINAL", "LC_TERMINAL_VERSION",
+		"VIM_TERMINAL", "KITTY_WINDOW_ID", "KONSOLE_VERSION", "VTE_VERSION", "WT_SESSION",
+		"TMUX", "STY", "COLORTERM",
+		ENV_PROTOCOL, ENV_CELL_SIZE, ENV_TMUX_PASSTHROUGH, ENV_QUERY_TIMEOUT,
+	} {
+		pT.Setenv(K, "")
+	}
+}
+
+func TestDetectEnv(pT *testing.T) {
+
+	clearTermEnv(pT)
+	pT.Setenv("TERM_PROGRAM", "WezTerm")
+	pT.Setenv("TERM_PROGRAM_VERSION", "20240203")
+	pT.Setenv("TMUX", "/tmp/tmux-1000/default,3218,4")
+	fakeTmux(pT, "exit 1")
+
+	C, E := Detect(context.Background(), DetectOpts{NoQuery: true})
+	if E != nil {
+		pT.Fatal(E)
+	}
+
+	if (C.TermName != "wezterm") || (C.TermVersion != "20240203") || (C.Source["term"] != "env:TERM_PROGRAM") {
+		pT.Errorf("unexpected terminal: %+v", C)
+	}
+
+	if (C.Multiplexer != "tmux") || (C.Recommended != PROTO_KITTY) {
+		pT.Errorf("unexpected capabilities: %+v", C)
+	}
+
+	if !C.Supports(PROTO_ITERM) || (C.Source["iterm"] != "env:TERM_PROGRAM") || (C.Source["sixel"] != "termdb:wezterm") {
+		pT.Errorf("unexpected protocols: %v %v", C.Protocols, C.Source)
+	}
+
+	clearTermEnv(pT)
+	pT.Setenv("TERM", "xterm-256color")
+
+	if C, _ = Detect(context.Background(), DetectOpts{NoQuery: true}); (C.Recommended != PROTO_NONE) || (len(C.Protocols) != 0) {
+		pT.Errorf("expected no protocols: %+v", C)
+	}
+}
+
+func TestEnvOverrides(pT *testing.T) {
+
+	clearTermEnv(pT)
+	pT.Setenv("TERM_PROGRAM", "WezTerm")
+	pT.Setenv(ENV_PROTOCOL, "Sixel")
+	pT.Setenv(ENV_CELL_SIZE, "9x18")
+	pT.Setenv(ENV_TMUX_PASSTHROUGH, "1")
+	pT.Setenv(ENV_QUERY_TIMEOUT, "bogus")
+
+	if IsKittyCapable() || IsItermCapable() {
+		pT.Error("override ignored by environment checks")
+	}
+
+	if bSix, E := IsSixelCapable(); !bSix || (E != nil) {
+		pT.Errorf("override ignored by IsSixelCapable: %v, %v", bSix, E)
+	}
+
+	if bOK, E := IsTmuxPassthroughEnabled(); !bOK || (E != nil) {
+		pT.Errorf("override ignored by IsTmuxPassthroughEnabled: %v, %v", bOK, E)
+	}
+
+	C, E := Detect(context.Background(), DetectOpts{NoQuery: true})
+	if E != nil {
+		pT.Fatal(E)
+	}
+
+	if (len(C.Protocols) != 1) || (C.Recommended != PROTO_SIXEL) || (C.Source["sixel"] != "env:"+ENV_PROTOCOL) {
+		pT.Errorf("unexpected protocols: %v %v", C.Protocols, C.Source)
+	}
+
+	if (C.Geometry.CellWidth != 9) || (C.Geometry.CellHeight != 18) {
+		pT.Errorf("unexpected geometry: %+v", C.Geometry)
+	}
+
+	cfg := EffectiveConfig()
+	if (cfg.Protocol != PROTO_SIXEL) || !cfg.TmuxPassthrough || (cfg.QueryTimeout != TERM_QUERY_TIMEOUT) {
+		pT.Errorf("unexpected config:\n%s", cfg)
+	}
+
+	if (len(cfg.Invalid) != 1) || (cfg.Invalid[0] != ENV_QUERY_TIMEOUT) {
+		pT.Errorf("unexpected invalid list: %v", cfg.Invalid)
+	}
+
+	pT.Setenv(ENV_PROTOCOL, "none")
+	pT.Setenv(ENV_QUERY_TIMEOUT, "75")
+	if C, _ = Detect(context.Background(), DetectOpts{NoQuery: true}); (C.Recommended != PROTO_NONE) || (len(C.Protocols) != 0) {
+		pT.Errorf("expected no protocols: %+v", C)
+	}
+
+	if d := defaultQueryTimeout(); d != 75*time.Millisecond {
+		pT.Errorf("unexpected query timeout: %v", d)
+	}
+}
+
+func TestParseReports(pT *testing.T) {
+
+	if v := parseXTSMGRAPHICS([]byte("\x1b[?1;0;256S"), 1); (len(v) != 1) || (v[0] != 256) {
+		pT.Errorf("XTSMGRAPHICS colors: %v", v)
+	}
+
+	if v := parseXTSMGRAPHICS([]byte("\x1b[?2;0;1000;800S"), 2); (len(v) != 2) || (v[1] != 800) {
+		pT.Errorf("XTSMGRAPHICS geometry: %v", v)
+	}
+
+	if v := parseXTSMGRAPHICS([]byte("\x1b[?2;3;0S"), 2); v != nil {
+		pT.Errorf("XTSMGRAPHICS failure status: %v", v)
+	}
+
+	if h, w, bOK := parseWinOpReport([]byte("\x1b[6;20;10t"), 6); !bOK || (h != 20) || (w != 10) {
+		pT.Errorf("cell size: %d x %d", w, h)
+	}
+
+	if _, _, bOK := parseWinOpReport([]byte("\x1b[4;600;800t"), 6); bOK {
+		pT.Error("matched wrong XTWINOPS report")
+	}
+
+	for spec, want := range map[string]color.RGBA64{
+		"rgb:ffff/8080/0000": {0xFFFF, 0x8080, 0, 0xFFFF},
+		"rgb:f/80/000":       {0xFFFF, 0x8080, 0, 0xFFFF},
+		"#1e1e2e":            {0x1e1e, 0x1e1e, 0x2e2e, 0xFFFF},
+	} {
+		if c, bOK := parseXColor(spec); !bOK || (c != want) {
+			pT.Errorf("%s: got %v", spec, c)
+		}
+	}
+
+	for _, spec := range []string{"", "rgb:ff/ff", "rgb:fffff/0/0", "#12345", "rgb:xx/00/00"} {
+		if _, bOK := parseXColor(spec); bOK {
+			pT.Errorf("%q: parsed", spec)
+		}
+	}
+
+	if !IsDarkColor(color.RGBA{0x1e, 0x1e, 0x2e, 0xFF}) || IsDarkColor(color.RGBA{0xfd, 0xf6, 0xe3, 0xFF}) {
+		pT.Error("dark/light misclassified")
+	}
+}
+
+func TestTermIdentification(pT *testing.T) {
+
+	tblXT := []struct{ rsp, name, ver string }{
+		{"\x1bP>|XTerm(388)\x1b\\", "xterm", "388"},
+		{"\x1bP>|WezTerm 20240203-110809-5046fc22\x1b\\", "wezterm", "20240203-110809-5046fc22"},
+		{"\x1bP>|foot(1.16.2)\x1b\\", "foot", "1.16.2"},
+		{"\x1bP>|Konsole 23.08.1\x1b\\", "konsole", "23.08.1"},
+		{"\x1bP>|kitty(0.31.0)\x1b\\", "kitty", "0.31.0"},
+		{"\x1bP>|tmux 3.4\x1b\\", "tmux", "3.4"},
+	}
+
+	for _, t := range tblXT {
+		s, bOK := parseXTVERSION([]byte(t.rsp))
+		id := termFromXTVersion(s)
+		if !bOK || (id.Name != t.name) || (id.Version != t.ver) {
+			pT.Errorf("%q: got %+v", t.rsp, id)
+		}
+	}
+
+	tblDA2 := []struct{ rsp, name, ver string }{
+		{"\x1b[>19;344;0c", "xterm", "344"},
+		{"\x1b[>0;0;0c", "wezterm", ""},
+		{"\x1b[>1;4000;19c", "kitty", ""},
+		{"\x1b[>77;30104;0c", "mintty", "3.1.4"},
+		{"\x1b[>65;5402;1c", "vte", "0.54.2"},
+		{"\x1b[>1;11602;0c", "", ""},
+		{"\x1b[>1;115;0c", "konsole", ""},
+		{"\x1b[>0;95;0c", "", ""},
+	}
+
+	for _, t := range tblDA2 {
+		id := termFromDA2(parseDA2([]byte(t.rsp)))
+		if (id.Name != t.name) || (id.Version != t.ver) {
+			pT.Errorf("%q: got %+v", t.rsp, id)
+		}
+	}
+}
+
+func TestGeometryDerive(pT *testing.T) {
+
+	// IOCTL-STYLE: CELLS & PIXELS KNOWN
+	g := TermGeometry{Cols: 80, Rows: 24, Width: 800, Height: 480}
+	g.derive()
+	if (g.CellWidth != 10) || (g.CellHeight != 20) || (g.Source["cell_size"] != "derived") || !g.HasPixels() {
+		pT.Errorf("cell size: %+v", g)
+	}
+
+	// CELL SIZE & CELLS KNOWN
+	g = TermGeometry{Cols: 100, Rows: 30, CellWidth: 9, CellHeight: 18}
+	g.derive()
+	if (g.Width != 900) || (g.Height != 540) {
+		pT.Errorf("pixels: %+v", g)
+	}
+
+	// CELLS ONLY: NO PIXELS TO DERIVE
+	g = TermGeometry{Cols: 100, Rows: 30}
+	g.derive()
+	if g.HasPixels() || (g.Width != 0) {
+		pT.Errorf("unexpected pixels: %+v", g)
+	}
+}
+
+func TestTmuxClient(pT *testing.T) {
+
+	clearTermEnv(pT)
+	pT.Setenv("TERM", "tmux-256color")
+	pT.Setenv("TERM_PROGRAM", "tmux")
+	pT.Setenv("TMUX", "/tmp/tmux-1000/default,3218,4")
+	fakeTmux(pT, `case "$1" in
+display)
+	case "$3" in
+	'#{client_termname}') echo xterm-kitty ;;
+	'#{client_termtype}') echo 'kitty(0.31.0)' ;;
+	esac ;;
+show-environment)
+	printf 'TERM_PROGRAM=WezTerm\n-LC_TERMINAL\nSSH_AUTH_SOCK=/tmp/agent\n' ;;
+*)
+	exit 1 ;;
+esac`)
+
+	V := GetEnvIdentifiers()
+	if (V["TERM"] != "xterm-kitty") || (V["TERM_PROGRAM"] != "wezterm") || (V["LC_TERMINAL"] != "") {
+		pT.Errorf("unexpected identifiers: %v", V)
+	}
+
+	tc, E := QueryTmuxClient()
+	if E != nil {
+		pT.Fatal(E)
+	}
+
+	if id := tc.Identify(); (id.Name != "kitty") || (id.Version != "0.31.0") || (id.Source != "tmux:client_termtype") {
+		pT.Errorf("unexpected identification: %+v", id)
+	}
+
+	C, _ := Detect(context.Background(), DetectOpts{NoQuery: true})
+	if (C.TermName != "kitty") || (C.Multiplexer != "tmux") || (C.Recommended != PROTO_KITTY) {
+		pT.Errorf("unexpected capabilities: %+v", C)
+	}
+
+	if s := tmuxWrapQuery("\x1b[>q"); s != "\x1bPtmux;\x1b\x1b[>q\x1b\\" {
+		pT.Errorf("unexpected wrapped query: %q", s)
+	}
+}
+
+func TestTmuxClientCached(pT *testing.T) {
+
+	clearTermEnv(pT)
+	pT.Setenv("TMUX", "/tmp/tmux-1000/default,3218,4")
+	pT.Setenv(ENV_TMUX_PASSTHROUGH, "1")
+
+	dir := pT.TempDir()
+	log, client := filepath.Join(dir, "calls"), filepath.Join(dir, "client")
+	os.WriteFile(client, []byte("4242 /dev/pts/3\n"), 0644)
+	fakeTmux(pT, `echo "$1" >> '`+log+`'
+case "$3" in
+'#{client_pid} #{client_tty}') cat '`+client+`' ;;
+'#{client_termname}') echo xterm-kitty ;;
+esac`)
+
+	// FULL QUERIES, EACH ENDING IN show-environment
+	queries := func() int {
+		b, _ := os.ReadFile(log)
+		return strings.Count(string(b), "show-environment")
+	}
+
+	GetEnvIdentifiers()
+	if queries() != 1 {
+		pT.Fatalf("expected 1 query, got %d", queries())
+	}
+
+	IsKittyCapable()
+	IsItermCapable()
+	Detect(context.Background(), DetectOpts{NoQuery: true})
+	if queries() != 1 {
+		pT.Errorf("expected 1 query, got %d", queries())
+	}
+
+	// REATTACHED FROM ANOTHER TERMINAL: ASKED AGAIN
+	os.WriteFile(client, []byte("5151 /dev/pts/7\n"), 0644)
+	GetEnvIdentifiers()
+	if queries() != 2 {
+		pT.Errorf("expected 2 queries, got %d", queries())
+	}
+
+	// ANOTHER TMUX SERVER: ASKED AGAIN
+	pT.Setenv("TMUX", "/tmp/tmux-1000/other,4000,0")
+	GetEnvIdentifiers()
+	if queries() != 3 {
+		pT.Errorf("expected 3 queries, got %d", queries())
+	}
+}
+
+func TestDetectSyncOutput(pT *testing.T) {
+
+	clearTermEnv(pT)
+
+	// PERMANENTLY RESET: RECOGNIZED, BUT NEVER ON
+	for rsp, want := range map[string]bool{
+		"\x1b[?2026;1$y": true,
+		"\x1b[?2026;2$y": true,
+		"\x1b[?2026;3$y": true,
+		"\x1b[?2026;4$y": false,
+		"\x1b[?2026;0$y": false,
+	} {
+		in, out := fakeTerminal(pT, map[string]string{
+			PROBE_SYNC_OUTPUT.Request: rsp,
+			"\x1b[0c":                 "\x1b[?62;22c",
+		}, false)
+
+		tio := NewTermIO(in, out, nil)
+		tio.Timeout = 100 * time.Millisecond
+
+		C, E := Detect(context.Background(), DetectOpts{IO: tio})
+		if (E != nil) || (C.SyncOutput != want) {
+			pT.Errorf("%q: expected SyncOutput %v, got %v, %v", rsp, want, C.SyncOutput, E)
+		}
+	}
+}
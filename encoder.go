@@ -0,0 +1,298 @@
+package rasterm
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"sync"
+)
+
+/*
+An image output protocol, for WriteImage & generic code.
+
+Name identifies the protocol, as in Capabilities.Protocols &
+RASTERM_PROTOCOL.  Supports reports whether a terminal can display it;
+it should honor C.Protocols where the protocol is detectable.  Encode
+writes one image at the cursor, sized per opts.Cols, opts.Rows &
+opts.Fit.  opts.Caps may be nil when called directly.
+
+Encoders should also implement Footprinter, for Footprint & cursor
+placement by WriteImage.
+*/
+type Encoder interface {
+	Name() Protocol
+	Supports(C *Capabilities) bool
+	Encode(ctx context.Context, out io.Writer, iImg image.Image, opts WriteOpts) error
+}
+
+var (
+	encMu    sync.RWMutex
+	encoders = []Encoder{KittyEncoder{}, ItermEncoder{}, SixelEncoder{}, BlocksEncoder{}}
+)
+
+/*
+Adds an encoder for WriteImage to consider, ahead of the built-in ones,
+so an application's own protocol wins where supported.  An encoder with
+the same name is replaced in place, e.g. to change how Sixel is
+quantized.
+*/
+func Register(enc Encoder) {
+
+	encMu.Lock()
+	defer encMu.Unlock()
+
+	for ix, v := range encoders {
+		if v.Name() == enc.Name() {
+			encoders[ix] = enc
+			return
+		}
+	}
+
+	encoders = append([]Encoder{enc}, encoders...)
+}
+
+// Returns the encoder registered under `name`.
+func Lookup(name Protocol) (Encoder, bool) {
+
+	encMu.RLock()
+	defer encMu.RUnlock()
+
+	for _, v := range encoders {
+		if v.Name() == name {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+// Registered encoders, in the order WriteImage tries them.
+func Encoders() []Encoder {
+
+	encMu.RLock()
+	defer encMu.RUnlock()
+
+	return append([]Encoder{}, encoders...)
+}
+
+// capabilities for an encoder, never nil
+func (o WriteOpts) caps() *Capabilities {
+
+	if o.Caps == nil {
+		return &Capabilities{}
+	}
+
+	return o.Caps
+}
+
+// opts.Matte, else the detected background, else nil for black
+func (o WriteOpts) matte() color.Color {
+
+	if o.Matte != nil {
+		return o.Matte
+	}
+
+	return o.caps().Background
+}
+
+// cells for the terminal to scale to, keeping FIT_CONTAIN's aspect
+// ratio by giving only the binding dimension
+func (o WriteOpts) terminalCells(b image.Rectangle) (int, int) {
+
+	cols, rows := o.Cols, o.Rows
+	if (cols <= 0) || (rows <= 0) || (o.Fit == FIT_FILL) {
+		return cols, rows
+	}
+
+	if _, r := fitCells(b, cols, 0, defaultCellWidth, defaultCellHeight); r <= rows {
+		return cols, 0
+	}
+
+	return 0, rows
+}
+
+/*
+Encoder for KittyWriteImage.  Scales in-package when the cell size is
+known, so the result doesn't depend on the terminal; otherwise leaves
+FIT_CONTAIN & FIT_FILL sizing to the terminal.
+*/
+type KittyEncoder struct{}
+
+func (KittyEncoder) Name() Protocol { return PROTO_KITTY }
+
+func (KittyEncoder) Supports(C *Capabilities) bool { return C.Supports(PROTO_KITTY) }
+
+func (KittyEncoder) Encode(ctx context.Context, out io.Writer, iImg image.Image, opts WriteOpts) error {
+
+	if E := ctx.Err(); E != nil {
+		return E
+	}
+
+	if opts.terminalScales() {
+		cols, rows := opts.terminalCells(iImg.Bounds())
+		return KittyWriteImage(out, iImg, KittyImgOpts{DstCols: uint32(cols), DstRows: uint32(rows)})
+	}
+
+	return KittyWriteImage(out, opts.scaleImage(iImg), KittyImgOpts{})
+}
+
+func (KittyEncoder) Footprint(iImg image.Image, opts WriteOpts) (int, int) {
+	return opts.scaledCells(iImg)
+}
+
+// cells covered where Kitty & iTerm draw at natural size, or scale to
+// terminalCells
+func (o WriteOpts) scaledCells(iImg image.Image) (int, int) {
+
+	g := o.caps().Geometry
+	if o.terminalScales() {
+		cw, ch := cellSize(g)
+		cols, rows := o.terminalCells(iImg.Bounds())
+		return fitCells(iImg.Bounds(), cols, rows, cw, ch)
+	}
+
+	w, h := o.scaledSize(iImg)
+	return pixelCells(w, h, g)
+}
+
+// Encoder for ItermWriteImageWithOptions.  Scales as KittyEncoder does.
+type ItermEncoder struct{}
+
+func (ItermEncoder) Name() Protocol { return PROTO_ITERM }
+
+func (ItermEncoder) Supports(C *Capabilities) bool { return C.Supports(PROTO_ITERM) }
+
+func (ItermEncoder) Encode(ctx context.Context, out io.Writer, iImg image.Image, opts WriteOpts) error {
+
+	if E := ctx.Err(); E != nil {
+		return E
+	}
+
+	iopts := ItermImgOpts{DisplayInline: true, Matte: opts.matte()}
+	if !opts.terminalScales() {
+		return ItermWriteImageWithOptions(out, opts.scaleImage(iImg), iopts)
+	}
+
+	if opts.Cols > 0 {
+		iopts.Width = strconv.Itoa(opts.Cols)
+	}
+	if opts.Rows > 0 {
+		iopts.Height = strconv.Itoa(opts.Rows)
+	}
+	iopts.IgnoreAspectRatio = (opts.Fit == FIT_FILL)
+
+	return ItermWriteImageWithOptions(out, iImg, iopts)
+}
+
+func (ItermEncoder) Footprint(iImg image.Image, opts WriteOpts) (int, int) {
+	return opts.scaledCells(iImg)
+}
+
+/*
+Encoder for SixelWriteImageWithOptions.  Takes any image: scales it to
+the target size in pixels (by the terminal's cell size), then dithers
+it to a built-in palette.  Paletted images that stay paletted (natural
+size, or pixel art scaled by nearest neighbor) are sent as-is.
+*/
+type SixelEncoder struct{}
+
+func (SixelEncoder) Name() Protocol { return PROTO_SIXEL }
+
+func (SixelEncoder) Supports(C *Capabilities) bool { return C.Supports(PROTO_SIXEL) }
+
+func (SixelEncoder) Encode(ctx context.Context, out io.Writer, iImg image.Image, opts WriteOpts) error {
+
+	if E := ctx.Err(); E != nil {
+		return E
+	}
+
+	return sixelWriteAny(out, iImg, opts.caps(), opts)
+}
+
+func (SixelEncoder) Footprint(iImg image.Image, opts WriteOpts) (int, int) {
+
+	C := opts.caps()
+	w, h := opts.scaledSize(iImg)
+	if so, bOK := sixelMaxOpts(image.Rect(0, 0, w, h), C, opts); bOK {
+		w, h = so.Size(image.Rect(0, 0, w, h), false)
+	}
+
+	// CURSOR ADVANCES BY WHOLE SIXEL BANDS
+	return pixelCells(w, roundUp(h, 6), C.Geometry)
+}
+
+/*
+Encoder for BlocksWriteImage.  Any terminal can show it.  Register one
+with another Mode or Colors to change how WriteImage draws text.
+*/
+type BlocksEncoder struct {
+	Mode   BlockMode
+	Colors ColorDepth
+}
+
+func (BlocksEncoder) Name() Protocol { return PROTO_BLOCKS }
+
+func (BlocksEncoder) Supports(*Capabilities) bool { return true }
+
+func (e BlocksEncoder) Encode(ctx context.Context, out io.Writer, iImg image.Image, opts WriteOpts) error {
+
+	if E := ctx.Err(); E != nil {
+		return E
+	}
+
+	// FIT IN PIXELS, THEN COVER THE RESULT WITH CELLS
+	if so, bOK := opts.textScaleOpts(); bOK {
+		iImg = Scale(iImg, so)
+	}
+
+	cols, rows := textCells(iImg.Bounds(), opts.caps().Geometry)
+
+	return BlocksWriteImage(out, iImg, BlocksOpts{
+		Cols:      cols,
+		Rows:      rows,
+		Matte:     opts.matte(),
+		Resampler: opts.Resampler,
+		Mode:      e.Mode,
+		Colors:    e.Colors,
+	})
+}
+
+func (BlocksEncoder) Footprint(iImg image.Image, opts WriteOpts) (int, int) {
+
+	b := iImg.Bounds()
+	if so, bOK := opts.textScaleOpts(); bOK {
+		_, bPal := iImg.(*image.Paletted)
+		w, h := so.Size(b, bPal)
+		b = image.Rect(0, 0, w, h)
+	}
+
+	return textCells(b, opts.caps().Geometry)
+}
+
+// how text renderers scale to Cols x Rows, or false for natural size
+func (o WriteOpts) textScaleOpts() (ScaleOpts, bool) {
+
+	if (o.Cols <= 0) && (o.Rows <= 0) {
+		return ScaleOpts{}, false
+	}
+
+	return ScaleOpts{
+		Cols:      o.Cols,
+		Rows:      o.Rows,
+		Geometry:  o.caps().Geometry,
+		Fit:       o.Fit,
+		Resampler: o.Resampler,
+	}, true
+}
+
+// cells for a `b` sized image drawn as text, narrowed to the terminal width
+func textCells(b image.Rectangle, g TermGeometry) (int, int) {
+
+	cw, ch := cellSize(g)
+	cols, rows := naturalCells(b, cw, ch, g.Cols)
+
+	// TEXT CELLS ARE 1x2 "PIXELS": CORRECT FOR THE REAL CELL SHAPE
+	return fitCells(b, cols, rows, cw, ch)
+}
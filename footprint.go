@@ -0,0 +1,107 @@
+package rasterm
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"strings"
+)
+
+// Where WriteImage or SixelWriteImageWithOptions leaves the cursor after
+// an image.
+type CursorPos int
+
+const (
+	CURSOR_PROTOCOL CursorPos = iota // wherever the protocol & terminal put it
+	CURSOR_BELOW                     // on the row below the image, at its left edge
+	CURSOR_BESIDE                    // on the image's top row, right of it
+)
+
+// An Encoder that knows how many cells its output covers.
+type Footprinter interface {
+	Footprint(iImg image.Image, opts WriteOpts) (cols, rows int)
+}
+
+/*
+Cells `iImg` covers when drawn with `proto` on a terminal with geometry
+`g`, per opts (Cols, Rows, Fit, Pad).  Counts partly covered cells: a
+Sixel image's height is rounded up to its 6-pixel bands first.
+opts.Caps, if set, supplies other limits, like Sixel's maximum size.
+
+Protocols without a Footprinter are estimated from their size in pixels
+after scaling.
+*/
+func Footprint(iImg image.Image, proto Protocol, g TermGeometry, opts WriteOpts) (int, int) {
+
+	if (proto == PROTO_NONE) || iImg.Bounds().Empty() {
+		return 0, 0
+	}
+
+	C := *opts.caps()
+	C.Geometry = g
+	opts.Caps = &C
+
+	enc, _ := Lookup(proto)
+	return encoderFootprint(enc, iImg, opts)
+}
+
+func encoderFootprint(enc Encoder, iImg image.Image, opts WriteOpts) (int, int) {
+
+	if fp, bOK := enc.(Footprinter); bOK {
+		return fp.Footprint(iImg, opts)
+	}
+
+	w, h := opts.scaledSize(iImg)
+	return pixelCells(w, h, opts.caps().Geometry)
+}
+
+// cells covered by w x h pixels
+func pixelCells(w, h int, g TermGeometry) (int, int) {
+	cw, ch := cellSize(g)
+	return (w + cw - 1) / cw, (h + ch - 1) / ch
+}
+
+/*
+Makes room for `rows` rows below the cursor, scrolling the screen if
+need be, & returns the cursor to where it was.  Drawing an image in that
+room then can't scroll the screen part way through.
+*/
+func ReserveSpace(out io.Writer, rows int) error {
+
+	if rows <= 0 {
+		return nil
+	}
+
+	// IND KEEPS THE COLUMN, UNLIKE LF UNDER ONLCR
+	_, E := fmt.Fprintf(out, "%s\x1b[%dA", strings.Repeat("\x1bD", rows), rows)
+	return E
+}
+
+// cursor control around an image covering cols x rows
+func (o WriteOpts) cursorWrap(cols, rows int) (pre, post string) {
+
+	var sb strings.Builder
+
+	if o.Reserve || (o.Cursor != CURSOR_PROTOCOL) {
+		ReserveSpace(&sb, rows)
+	}
+
+	if o.Cursor != CURSOR_PROTOCOL {
+		return sb.String() + ESC_CURSOR_SAVE, cursorAfter(o.Cursor, cols, rows)
+	}
+
+	return sb.String(), ""
+}
+
+// from the cursor saved at an image's top left to `pos` for cols x rows
+func cursorAfter(pos CursorPos, cols, rows int) string {
+
+	switch pos {
+	case CURSOR_BELOW:
+		return fmt.Sprintf("%s\x1b[%dB", ESC_CURSOR_RESTORE, max1(rows))
+	case CURSOR_BESIDE:
+		return fmt.Sprintf("%s\x1b[%dC", ESC_CURSOR_RESTORE, max1(cols))
+	}
+
+	return ""
+}
@@ -0,0 +1,123 @@
+package rasterm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"strings"
+	"testing"
+)
+
+func TestFootprint(pT *testing.T) {
+
+	clearTermEnv(pT)
+
+	g := TermGeometry{Cols: 80, Rows: 24, Width: 800, Height: 480, CellWidth: 10, CellHeight: 20}
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	odd := image.NewRGBA(image.Rect(0, 0, 45, 30))
+
+	type tcase struct {
+		img        image.Image
+		proto      Protocol
+		g          TermGeometry
+		opts       WriteOpts
+		cols, rows int
+	}
+
+	sTests := []tcase{
+		{img, PROTO_KITTY, g, WriteOpts{}, 4, 1},
+		{img, PROTO_ITERM, g, WriteOpts{}, 4, 1},
+		{img, PROTO_BLOCKS, g, WriteOpts{}, 4, 1},
+		{img, PROTO_NONE, g, WriteOpts{}, 0, 0},
+
+		// 20 PX HIGH: 4 BANDS = 24 PX
+		{img, PROTO_SIXEL, g, WriteOpts{}, 4, 2},
+
+		{img, PROTO_KITTY, g, WriteOpts{Cols: 10}, 10, 3},
+		{img, PROTO_KITTY, g, WriteOpts{Cols: 10, Rows: 1}, 4, 1},
+		{img, PROTO_KITTY, g, WriteOpts{Cols: 10, Rows: 1, Fit: FIT_FILL}, 10, 1},
+		{img, PROTO_BLOCKS, g, WriteOpts{Cols: 10, Rows: 1, Fit: FIT_COVER}, 10, 1},
+
+		// UNKNOWN CELL SIZE: TERMINAL SCALES TO THE BINDING DIMENSION
+		{img, PROTO_KITTY, TermGeometry{}, WriteOpts{Cols: 10, Rows: 10}, 10, 3},
+
+		{odd, PROTO_KITTY, g, WriteOpts{}, 5, 2},
+		{odd, PROTO_SIXEL, g, WriteOpts{}, 5, 2},
+
+		// PADDED TO 50 x 40, BANDED TO 42
+		{odd, PROTO_SIXEL, g, WriteOpts{Pad: true}, 5, 3},
+		{odd, PROTO_ITERM, g, WriteOpts{Pad: true}, 5, 2},
+
+		// NO FOOTPRINTER: ESTIMATED FROM PIXELS
+		{odd, "acme", g, WriteOpts{}, 5, 2},
+	}
+
+	for _, t := range sTests {
+		if cols, rows := Footprint(t.img, t.proto, t.g, t.opts); (cols != t.cols) || (rows != t.rows) {
+			pT.Errorf("%s %v %+v: expected %dx%d, got %dx%d",
+				t.proto, t.img.Bounds().Size(), t.opts, t.cols, t.rows, cols, rows)
+		}
+	}
+}
+
+func TestPadImage(pT *testing.T) {
+
+	pI := image.NewPaletted(image.Rect(2, 3, 7, 6), palette.WebSafe)
+	for ix := range pI.Pix {
+		pI.Pix[ix] = 5
+	}
+
+	out, bOK := padImage(pI, 10, 20).(*image.Paletted)
+	if !bOK {
+		pT.Fatal("expected paletted")
+	}
+	if b := out.Bounds(); b != image.Rect(0, 0, 10, 20) {
+		pT.Fatalf("bounds: %v", b)
+	}
+	if (out.ColorIndexAt(4, 2) != 5) || (out.At(5, 2) != color.Transparent) || (out.At(0, 3) != color.Transparent) {
+		pT.Error("image or padding misplaced")
+	}
+
+	// FULL PALETTE: RGBA
+	full := image.NewPaletted(image.Rect(0, 0, 5, 3), palette.Plan9)
+	if _, bOK := padImage(full, 10, 20).(*image.RGBA); !bOK {
+		pT.Error("expected RGBA")
+	}
+}
+
+func TestWriteImageCursor(pT *testing.T) {
+
+	clearTermEnv(pT)
+
+	C := &Capabilities{
+		Protocols: []Protocol{PROTO_SIXEL},
+		Geometry:  TermGeometry{Cols: 80, Rows: 24, Width: 800, Height: 480, CellWidth: 10, CellHeight: 20},
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+
+	buf := new(bytes.Buffer)
+	if E := WriteImage(buf, img, WriteOpts{Caps: C, Cursor: CURSOR_BELOW}); E != nil {
+		pT.Fatal(E)
+	}
+	s := buf.String()
+	if !strings.HasPrefix(s, "\x1bD\x1bD\x1b[2A\x1b7\x1bP") || !strings.HasSuffix(s, "\x1b\\\x1b8\x1b[2B") {
+		pT.Errorf("expected reserve & cursor below: %q ... %q", s[:16], s[len(s)-10:])
+	}
+
+	buf.Reset()
+	if E := WriteImage(buf, img, WriteOpts{Caps: C, Cursor: CURSOR_BESIDE}); E != nil {
+		pT.Fatal(E)
+	}
+	if s := buf.String(); !strings.HasSuffix(s, "\x1b8\x1b[4C") {
+		pT.Errorf("expected cursor beside: %q", s[len(s)-10:])
+	}
+
+	buf.Reset()
+	if E := WriteImage(buf, img, WriteOpts{Caps: C}); E != nil {
+		pT.Fatal(E)
+	}
+	if s := buf.String(); !strings.HasPrefix(s, "\x1bP") {
+		pT.Errorf("expected sixel only: %.16q", s)
+	}
+}
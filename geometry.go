@@ -0,0 +1,134 @@
+package rasterm
+
+import (
+	"context"
+)
+
+/*
+Terminal text area size in cells & pixels, see QueryGeometry.
+
+Fields are 0 when unknown.  `Source` records how each group was
+determined, keyed by "cells", "pixels" & "cell_size", with values like
+"query:XTWINOPS 18", "ioctl:TIOCGWINSZ" or "derived".
+*/
+type TermGeometry struct {
+	Cols       int
+	Rows       int
+	Width      int // text area pixels
+	Height     int
+	CellWidth  int // pixels per cell
+	CellHeight int
+
+	Source map[string]string
+}
+
+// Cell & text area pixel sizes are both known.
+func (g TermGeometry) HasPixels() bool {
+	return (g.CellWidth > 0) && (g.CellHeight > 0) && (g.Width > 0) && (g.Height > 0)
+}
+
+/*
+NOTE: the calling program MUST be connected to an actual terminal for this to work
+
+Queries terminal geometry with XTWINOPS:
+
+	CSI 14 t  ⇒  CSI 4 ; height ; width t   (text area pixels)
+	CSI 16 t  ⇒  CSI 6 ; height ; width t   (cell pixels)
+	CSI 18 t  ⇒  CSI 8 ; rows ; cols t      (text area cells)
+
+All three are sent in one Probe.  Whatever the terminal doesn't answer
+is filled in from the TIOCGWINSZ ioctl (ws_row, ws_col, ws_xpixel,
+ws_ypixel), then derived from the other values where possible.
+*/
+func QueryGeometry() (TermGeometry, error) {
+	return StdTermIO().QueryGeometry(context.Background())
+}
+
+// See QueryGeometry.  The TIOCGWINSZ fallback only applies to a TermIO
+// from NewFileTermIO.
+func (t *TermIO) QueryGeometry(ctx context.Context) (TermGeometry, error) {
+
+	R, eQuery := t.Probe(ctx, ProbeOpts{
+		Queries: []ProbeQuery{PROBE_WINDOW_PIXELS, PROBE_CELL_PIXELS, PROBE_TEXT_CELLS},
+	})
+
+	g := t.geometryFromProbe(R)
+	if (g.Cols == 0) && (g.Width == 0) && (g.CellWidth == 0) {
+		if eQuery == nil {
+			eQuery = E_BAD_RESPONSE
+		}
+		return g, eQuery
+	}
+
+	return g, nil
+}
+
+// geometry from XTWINOPS replies (R may be nil), then TIOCGWINSZ, then derived
+func (t *TermIO) geometryFromProbe(R *ProbeResult) TermGeometry {
+
+	g := TermGeometry{Source: make(map[string]string)}
+
+	if R != nil {
+
+		if h, w, bOK := parseWinOpReport(R.Replies[PROBE_WINDOW_PIXELS.Name], 4); bOK {
+			g.Width, g.Height, g.Source["pixels"] = w, h, "query:XTWINOPS 14"
+		}
+
+		if h, w, bOK := parseWinOpReport(R.Replies[PROBE_CELL_PIXELS.Name], 6); bOK {
+			g.CellWidth, g.CellHeight, g.Source["cell_size"] = w, h, "query:XTWINOPS 16"
+		}
+
+		if r, c, bOK := parseWinOpReport(R.Replies[PROBE_TEXT_CELLS.Name], 8); bOK {
+			g.Cols, g.Rows, g.Source["cells"] = c, r, "query:XTWINOPS 18"
+		}
+	}
+
+	// IOCTL FALLBACK
+	if (t.fileIN != nil) && ((g.Cols == 0) || (g.Width == 0)) {
+
+		cols, rows, xpix, ypix, E := getWinsize(int(t.fileOUT.Fd()))
+		if E != nil {
+			cols, rows, xpix, ypix, E = getWinsize(int(t.fileIN.Fd()))
+		}
+
+		if E == nil {
+			if (g.Cols == 0) && (cols > 0) && (rows > 0) {
+				g.Cols, g.Rows, g.Source["cells"] = cols, rows, "ioctl:TIOCGWINSZ"
+			}
+			if (g.Width == 0) && (xpix > 0) && (ypix > 0) {
+				g.Width, g.Height, g.Source["pixels"] = xpix, ypix, "ioctl:TIOCGWINSZ"
+			}
+		}
+	}
+
+	// USER SAYS OTHERWISE
+	if O := EnvOverrides(); O.CellWidth > 0 {
+		g.CellWidth, g.CellHeight, g.Source["cell_size"] = O.CellWidth, O.CellHeight, "env:"+ENV_CELL_SIZE
+	}
+
+	g.derive()
+	return g
+}
+
+// fills unknown values from the known ones
+func (g *TermGeometry) derive() {
+
+	if g.Source == nil {
+		g.Source = make(map[string]string)
+	}
+
+	if (g.CellWidth == 0) && (g.Width > 0) && (g.Cols > 0) && (g.Rows > 0) {
+		g.CellWidth, g.CellHeight = g.Width/g.Cols, g.Height/g.Rows
+		g.Source["cell_size"] = "derived"
+	}
+
+	if (g.Width == 0) && (g.CellWidth > 0) && (g.Cols > 0) {
+		g.Width, g.Height = g.CellWidth*g.Cols, g.CellHeight*g.Rows
+		g.Source["pixels"] = "derived"
+	}
+
+	if (g.Cols == 0) && (g.CellWidth > 0) && (g.Width > 0) {
+		g.Cols, g.Rows = g.Width/g.CellWidth, g.Height/g.CellHeight
+		g.Source["cells"] = "derived"
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package rasterm
+
+import (
+	"golang.org/x/term"
+)
+
+// no TIOCGWINSZ: cells only, via x/term
+func getWinsize(fd int) (cols, rows, xpix, ypix int, E error) {
+	cols, rows, E = term.GetSize(fd)
+	return
+}
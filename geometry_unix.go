@@ -0,0 +1,19 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package rasterm
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// TIOCGWINSZ: cells & pixels (pixels are 0 if the terminal doesn't report them)
+func getWinsize(fd int) (cols, rows, xpix, ypix int, E error) {
+
+	ws, E := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if E != nil {
+		return
+	}
+
+	return int(ws.Col), int(ws.Row), int(ws.Xpixel), int(ws.Ypixel), nil
+}
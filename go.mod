@@ -2,4 +2,7 @@ module github.com/BourgeoisBear/rasterm
 
 go 1.16
 
-require golang.org/x/term v0.18.0
+require (
+	golang.org/x/sys v0.18.0
+	golang.org/x/term v0.18.0
+)
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"image"
+	"image/color"
 	"image/jpeg"
 	"image/png"
 	"io"
@@ -43,6 +44,10 @@ type ItermImgOpts struct {
 
 	// If set, the image's inherent aspect ratio will not be respected.
 	IgnoreAspectRatio bool
+
+	// Color transparent pixels are flattened onto when encoding JPEG.
+	// Defaults to black; Capabilities.Background is the terminal's.
+	Matte color.Color
 }
 
 func (o ItermImgOpts) ToHeader() string {
@@ -81,26 +86,37 @@ func (o ItermImgOpts) ToHeader() string {
 // NOTE: uses $TERM_PROGRAM, which isn't passed through tmux or ssh
 // checks if iterm inline image protocol is supported
 func IsItermCapable() bool {
+	return itermEnvKey(GetEnvIdentifiers()) != ""
+}
 
-	V := GetEnvIdentifiers()
+// name of the environment identifier indicating iterm support, or ""
+func itermEnvKey(V map[string]string) string {
+
+	if p, bOK := protocolOverride(V); bOK {
+		if p == PROTO_ITERM {
+			return ENV_PROTOCOL
+		}
+		return ""
+	}
 
-	if V["TERM"] == "mintty" {
-		return true
+	switch V["TERM"] {
+	case "mintty", "rio":
+		return "TERM"
 	}
 
 	if V["LC_TERMINAL"] == "iterm2" {
-		return true
+		return "LC_TERMINAL"
 	}
 
 	if V["TERM_PROGRAM"] == "wezterm" {
-		return true
+		return "TERM_PROGRAM"
 	}
 
 	if V["TERM_PROGRAM"] == "rio" {
-		return true
+		return "TERM_PROGRAM"
 	}
 
-	return false
+	return ""
 }
 
 /*
@@ -121,8 +137,8 @@ func ItermWriteImageWithOptions(out io.Writer, iImg image.Image, opts ItermImgOp
 
 	} else {
 
-		// JPG IF NOT
-		E = jpeg.Encode(pBuf, iImg, &jpeg.Options{Quality: 93})
+		// JPG IF NOT, W/O ALPHA
+		E = jpeg.Encode(pBuf, flattenAlpha(iImg, opts.Matte), &jpeg.Options{Quality: 93})
 	}
 
 	if E != nil {
@@ -70,8 +70,34 @@ func (o KittyImgOpts) ToHeader(opts ...string) string {
 func IsKittyCapable() bool {
 
 	// TODO: more rigorous check
-	V := GetEnvIdentifiers()
-	return (len(V["KITTY_WINDOW_ID"]) > 0) || (V["TERM_PROGRAM"] == "wezterm") || (V["TERM_PROGRAM"] == "ghostty")
+	return kittyEnvKey(GetEnvIdentifiers()) != ""
+}
+
+// name of the environment identifier indicating kitty support, or ""
+func kittyEnvKey(V map[string]string) string {
+
+	if p, bOK := protocolOverride(V); bOK {
+		if p == PROTO_KITTY {
+			return ENV_PROTOCOL
+		}
+		return ""
+	}
+
+	if len(V["KITTY_WINDOW_ID"]) > 0 {
+		return "KITTY_WINDOW_ID"
+	}
+
+	switch V["TERM_PROGRAM"] {
+	case "wezterm", "ghostty":
+		return "TERM_PROGRAM"
+	}
+
+	switch V["TERM"] {
+	case "xterm-kitty", "xterm-ghostty":
+		return "TERM"
+	}
+
+	return ""
 }
 
 // Display local PNG file
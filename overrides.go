@@ -0,0 +1,241 @@
+package rasterm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+User corrections for when detection guesses wrong (nested ssh, editor
+terminals, CI logs), set in the environment:
+
+	RASTERM_PROTOCOL=kitty|iterm|sixel|blocks|none, or a registered Encoder
+	RASTERM_CELL_SIZE=WxH              cell size in pixels, e.g. 10x20
+	RASTERM_TMUX_PASSTHROUGH=0|1       wrap output for tmux, or don't
+	RASTERM_QUERY_TIMEOUT=250ms        or plain milliseconds
+
+Zero values are unset.  Variables with unusable values are ignored, and
+listed in `Invalid`.
+*/
+type Overrides struct {
+	Protocol Protocol
+
+	CellWidth  int
+	CellHeight int
+
+	TmuxPassthrough    bool
+	HasTmuxPassthrough bool
+
+	QueryTimeout time.Duration
+
+	Invalid []string
+}
+
+const (
+	ENV_PROTOCOL         = "RASTERM_PROTOCOL"
+	ENV_CELL_SIZE        = "RASTERM_CELL_SIZE"
+	ENV_TMUX_PASSTHROUGH = "RASTERM_TMUX_PASSTHROUGH"
+	ENV_QUERY_TIMEOUT    = "RASTERM_QUERY_TIMEOUT"
+)
+
+// Reads RASTERM_* variables from the environment.  See Overrides.
+func EnvOverrides() Overrides {
+
+	var O Overrides
+
+	if s := lcaseEnv(ENV_PROTOCOL); s != "" {
+		if p, bOK := parseProtocol(s); bOK {
+			O.Protocol = p
+		} else {
+			O.Invalid = append(O.Invalid, ENV_PROTOCOL)
+		}
+	}
+
+	if s := lcaseEnv(ENV_CELL_SIZE); s != "" {
+		w, h, bOK := parseCellSize(s)
+		if bOK {
+			O.CellWidth, O.CellHeight = w, h
+		} else {
+			O.Invalid = append(O.Invalid, ENV_CELL_SIZE)
+		}
+	}
+
+	switch s := lcaseEnv(ENV_TMUX_PASSTHROUGH); s {
+	case "":
+	case "1", "on", "true", "yes":
+		O.TmuxPassthrough, O.HasTmuxPassthrough = true, true
+	case "0", "off", "false", "no":
+		O.TmuxPassthrough, O.HasTmuxPassthrough = false, true
+	default:
+		O.Invalid = append(O.Invalid, ENV_TMUX_PASSTHROUGH)
+	}
+
+	if s := lcaseEnv(ENV_QUERY_TIMEOUT); s != "" {
+		if d, bOK := parseTimeout(s); bOK {
+			O.QueryTimeout = d
+		} else {
+			O.Invalid = append(O.Invalid, ENV_QUERY_TIMEOUT)
+		}
+	}
+
+	return O
+}
+
+func parseProtocol(s string) (Protocol, bool) {
+
+	p := Protocol(s)
+	if p == PROTO_NONE {
+		return p, true
+	}
+
+	// BUILT-IN, OR REGISTERED BY THE APPLICATION
+	_, bOK := Lookup(p)
+	return p, bOK
+}
+
+// "WxH", e.g. "10x20"
+func parseCellSize(s string) (w, h int, bOK bool) {
+
+	sW, sH, bSep := strings.Cut(s, "x")
+	if !bSep {
+		return 0, 0, false
+	}
+
+	w, eW := strconv.Atoi(strings.TrimSpace(sW))
+	h, eH := strconv.Atoi(strings.TrimSpace(sH))
+	if (eW != nil) || (eH != nil) || (w <= 0) || (h <= 0) {
+		return 0, 0, false
+	}
+
+	return w, h, true
+}
+
+// Go duration, or whole milliseconds
+func parseTimeout(s string) (time.Duration, bool) {
+
+	if n, E := strconv.Atoi(s); E == nil {
+		return time.Duration(n) * time.Millisecond, n > 0
+	}
+
+	d, E := time.ParseDuration(s)
+	return d, (E == nil) && (d > 0)
+}
+
+// RASTERM_QUERY_TIMEOUT, or TERM_QUERY_TIMEOUT
+func defaultQueryTimeout() time.Duration {
+
+	if O := EnvOverrides(); O.QueryTimeout > 0 {
+		return O.QueryTimeout
+	}
+
+	return TERM_QUERY_TIMEOUT
+}
+
+/*
+Settings in effect after RASTERM_* overrides, see EffectiveConfig.
+`Source` says where each came from, keyed by field name, e.g.
+"env:RASTERM_PROTOCOL" or "default".
+*/
+type Config struct {
+	Protocol        Protocol // "" if left to detection
+	CellWidth       int      // 0 if left to detection
+	CellHeight      int
+	TmuxPassthrough bool
+	QueryTimeout    time.Duration
+	TTYMode         TTYMode
+
+	Invalid []string // ignored RASTERM_* variables
+	Source  map[string]string
+}
+
+/*
+Reports the configuration in effect, for diagnostics.  Doesn't query
+the terminal, though it does ask tmux about passthrough when inside
+tmux & RASTERM_TMUX_PASSTHROUGH is unset.
+*/
+func EffectiveConfig() Config {
+
+	O := EnvOverrides()
+	C := Config{
+		Protocol:     O.Protocol,
+		CellWidth:    O.CellWidth,
+		CellHeight:   O.CellHeight,
+		QueryTimeout: defaultQueryTimeout(),
+		TTYMode:      TTY_MODE,
+		Invalid:      O.Invalid,
+		Source: map[string]string{
+			"Protocol":        "detect",
+			"CellSize":        "detect",
+			"TmuxPassthrough": "default",
+			"QueryTimeout":    "TERM_QUERY_TIMEOUT",
+			"TTYMode":         "TTY_MODE",
+		},
+	}
+
+	if O.Protocol != "" {
+		C.Source["Protocol"] = "env:" + ENV_PROTOCOL
+	}
+
+	if O.CellWidth > 0 {
+		C.Source["CellSize"] = "env:" + ENV_CELL_SIZE
+	}
+
+	if O.QueryTimeout > 0 {
+		C.Source["QueryTimeout"] = "env:" + ENV_QUERY_TIMEOUT
+	}
+
+	if O.HasTmuxPassthrough {
+		C.TmuxPassthrough, C.Source["TmuxPassthrough"] = O.TmuxPassthrough, "env:"+ENV_TMUX_PASSTHROUGH
+	} else if IsTmux() {
+		C.TmuxPassthrough = wantTmuxPassthrough()
+		C.Source["TmuxPassthrough"] = "tmux:allow-passthrough"
+	}
+
+	return C
+}
+
+func (c Config) String() string {
+
+	sb := strings.Builder{}
+
+	proto := string(c.Protocol)
+	if proto == "" {
+		proto = "auto"
+	}
+
+	cell := "auto"
+	if c.CellWidth > 0 {
+		cell = fmt.Sprintf("%dx%d", c.CellWidth, c.CellHeight)
+	}
+
+	fmt.Fprintf(&sb, "protocol:         %s (%s)\n", proto, c.Source["Protocol"])
+	fmt.Fprintf(&sb, "cell size:        %s (%s)\n", cell, c.Source["CellSize"])
+	fmt.Fprintf(&sb, "tmux passthrough: %v (%s)\n", c.TmuxPassthrough, c.Source["TmuxPassthrough"])
+	fmt.Fprintf(&sb, "query timeout:    %v (%s)\n", c.QueryTimeout, c.Source["QueryTimeout"])
+	fmt.Fprintf(&sb, "tty mode:         %s (%s)\n", c.TTYMode, c.Source["TTYMode"])
+
+	if len(c.Invalid) > 0 {
+		fmt.Fprintf(&sb, "ignored:          %s\n", strings.Join(c.Invalid, ", "))
+	}
+
+	return sb.String()
+}
+
+// protocol forced by RASTERM_PROTOCOL, in environment identifiers `V`
+func protocolOverride(V map[string]string) (Protocol, bool) {
+	return parseProtocol(V[ENV_PROTOCOL])
+}
+
+// queries should be wrapped for tmux: RASTERM_TMUX_PASSTHROUGH, else
+// inside tmux with allow-passthrough
+func wantTmuxPassthrough() bool {
+
+	if !IsTmux() && !EnvOverrides().HasTmuxPassthrough {
+		return false
+	}
+
+	bOK, _ := IsTmuxPassthroughEnabled()
+	return bOK
+}
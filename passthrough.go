@@ -0,0 +1,372 @@
+package rasterm
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	TMUX_PASSTHROUGH_HDR = "\x1bPtmux;"
+	TMUX_PASSTHROUGH_FTR = "\x1b\\"
+
+	SCREEN_PASSTHROUGH_HDR = "\x1bP"
+	SCREEN_PASSTHROUGH_FTR = "\x1b\\"
+
+	// GNU screen truncates DCS strings beyond its internal buffer
+	// (MAXSTR, 768 bytes); stay well below it.
+	SCREEN_CHUNK_SIZE = 512
+)
+
+/*
+Wraps everything written in tmux passthrough sequences, so escape
+sequences reach the outer terminal instead of being interpreted by tmux:
+
+	ESC P tmux ; <data, each ESC doubled> ESC \
+
+Output is sent up to the end of the last complete escape sequence, so
+each passthrough sequence carries whole inner sequences however callers
+split their writes, & tmux can't interleave its own output mid-sequence.
+Sequences are capped at TMUX_PASSTHROUGH_MAX bytes, below tmux's input
+buffer limit; a longer inner sequence is split across several.  Flush or
+Close sends anything left over.
+
+Requires `allow-passthrough` in tmux 3.3+, see IsTmuxPassthroughEnabled.
+*/
+type TmuxPassthroughWri struct {
+	iWri io.Writer
+
+	pend   []byte // escaped data not yet sent
+	nBound int    // length of `pend` at the last inner sequence boundary
+	st     escState
+}
+
+// tmux drops passthrough sequences beyond its input buffer (1 MiB by
+// default); stay well below it
+const TMUX_PASSTHROUGH_MAX = 256 * 1024
+
+func NewTmuxPassthroughWriter(out io.Writer) *TmuxPassthroughWri {
+	return &TmuxPassthroughWri{iWri: out}
+}
+
+func (w *TmuxPassthroughWri) Write(buf []byte) (int, error) {
+
+	for _, b := range buf {
+
+		if b == 0x1b {
+			w.pend = append(w.pend, 0x1b)
+		}
+		w.pend = append(w.pend, b)
+
+		if w.st = w.st.next(b); w.st == escGround {
+			w.nBound = len(w.pend)
+		}
+
+		if len(w.pend) >= TMUX_PASSTHROUGH_MAX {
+
+			// WHOLE SEQUENCES IF ANY, ELSE SPLIT THE ONE TOO LONG
+			n := w.nBound
+			if n == 0 {
+				n = len(w.pend)
+			}
+
+			if E := w.emit(n); E != nil {
+				return 0, E
+			}
+		}
+	}
+
+	// WHOLE SEQUENCES NOW, THE REST WHEN COMPLETE
+	if E := w.emit(w.nBound); E != nil {
+		return 0, E
+	}
+
+	return len(buf), nil
+}
+
+// Sends buffered output, even part of an escape sequence.
+func (w *TmuxPassthroughWri) Flush() error {
+	return w.emit(len(w.pend))
+}
+
+func (w *TmuxPassthroughWri) Close() error {
+	return w.Flush()
+}
+
+// sends the first `n` bytes of `pend` as one passthrough sequence
+func (w *TmuxPassthroughWri) emit(n int) error {
+
+	if n == 0 {
+		return nil
+	}
+
+	tmp := make([]byte, 0, len(TMUX_PASSTHROUGH_HDR)+n+len(TMUX_PASSTHROUGH_FTR))
+	tmp = append(tmp, TMUX_PASSTHROUGH_HDR...)
+	tmp = append(tmp, w.pend[:n]...)
+	tmp = append(tmp, TMUX_PASSTHROUGH_FTR...)
+
+	w.pend = append(w.pend[:0], w.pend[n:]...)
+	w.nBound -= n
+	if w.nBound < 0 {
+		w.nBound = 0
+	}
+
+	_, E := w.iWri.Write(tmp)
+	return E
+}
+
+// where an output stream is, relative to escape sequences
+type escState int
+
+const (
+	escGround    escState = iota
+	escEsc                // after ESC
+	escIntermed           // ESC, intermediates
+	escCSI                // ESC [ ...
+	escString             // DCS, APC, PM, SOS: until ST
+	escOSC                // OSC: until ST or BEL
+	escStringEsc          // ESC inside a string
+	escOSCEsc             // ESC inside an OSC
+)
+
+func (st escState) next(b byte) escState {
+
+	switch st {
+	case escGround:
+
+		if b == 0x1b {
+			return escEsc
+		}
+		return escGround
+
+	case escEsc:
+
+		switch {
+		case (b == 'P') || (b == '_') || (b == '^') || (b == 'X'):
+			return escString
+		case b == ']':
+			return escOSC
+		case b == '[':
+			return escCSI
+		case (b >= 0x20) && (b <= 0x2F):
+			return escIntermed
+		case b == 0x1b:
+			return escEsc
+		}
+		return escGround
+
+	case escIntermed:
+
+		if (b >= 0x20) && (b <= 0x2F) {
+			return escIntermed
+		}
+		return escGround
+
+	case escCSI:
+
+		if (b >= 0x40) && (b <= 0x7E) {
+			return escGround
+		}
+		return escCSI
+
+	case escString, escOSC:
+
+		if b == 0x1b {
+			return st + 2
+		}
+		if (st == escOSC) && (b == 0x07) {
+			return escGround
+		}
+		return st
+
+	case escStringEsc, escOSCEsc:
+
+		// ST, OR A NEW SEQUENCE CUTTING THE STRING SHORT
+		if b == '\\' {
+			return escGround
+		}
+		return escEsc.next(b)
+	}
+
+	return escGround
+}
+
+// checks if running inside tmux
+func IsTmux() bool {
+	return len(os.Getenv("TMUX")) > 0
+}
+
+/*
+Checks tmux's `allow-passthrough` option with:
+
+	tmux show -gv allow-passthrough
+
+tmux before 3.3 lacks the option & always passes through, so an
+"invalid option" reply counts as enabled.  RASTERM_TMUX_PASSTHROUGH
+takes precedence.
+*/
+func IsTmuxPassthroughEnabled() (bool, error) {
+
+	if O := EnvOverrides(); O.HasTmuxPassthrough {
+		return O.TmuxPassthrough, nil
+	}
+
+	out, E := exec.Command("tmux", "show", "-gv", "allow-passthrough").CombinedOutput()
+	sOut := strings.ToLower(strings.TrimSpace(string(out)))
+
+	if E != nil {
+		if strings.Contains(sOut, "invalid option") || strings.Contains(sOut, "unknown option") {
+			return true, nil
+		}
+		return false, E
+	}
+
+	return (sOut == "on") || (sOut == "all"), nil
+}
+
+/*
+Wraps everything written in GNU screen passthrough sequences:
+
+	ESC P <data> ESC \
+
+screen limits DCS string length, so data is split across as many
+sequences as needed, at most SCREEN_CHUNK_SIZE bytes each.  screen
+passes a DCS string's bytes on as-is, taking an ESC that isn't followed
+by \ literally.  So an inner ST (ESC \) is split between two sequences,
+the first ending in ESC ESC \: a literal ESC, then the outer ST.
+
+As with TmuxPassthroughWri, output is sent up to the end of the last
+complete escape sequence, at most SCREEN_PASSTHROUGH_MAX bytes at once.
+Flush or Close sends anything left over.
+*/
+type ScreenPassthroughWri struct {
+	iWri       io.Writer
+	nChunkSize int
+
+	pend   []byte // data not yet sent
+	nBound int    // length of `pend` at the last inner sequence boundary
+	st     escState
+}
+
+// most data held back for the end of an inner sequence
+const SCREEN_PASSTHROUGH_MAX = 256 * 1024
+
+func NewScreenPassthroughWriter(out io.Writer) *ScreenPassthroughWri {
+	return &ScreenPassthroughWri{iWri: out, nChunkSize: SCREEN_CHUNK_SIZE}
+}
+
+func (w *ScreenPassthroughWri) Write(buf []byte) (int, error) {
+
+	for _, b := range buf {
+
+		w.pend = append(w.pend, b)
+
+		if w.st = w.st.next(b); w.st == escGround {
+			w.nBound = len(w.pend)
+		}
+
+		if len(w.pend) >= SCREEN_PASSTHROUGH_MAX {
+
+			n := w.nBound
+			if n == 0 {
+				n = len(w.pend)
+			}
+
+			if E := w.emit(n); E != nil {
+				return 0, E
+			}
+		}
+	}
+
+	if E := w.emit(w.nBound); E != nil {
+		return 0, E
+	}
+
+	return len(buf), nil
+}
+
+// Sends buffered output, even part of an escape sequence.
+func (w *ScreenPassthroughWri) Flush() error {
+	return w.emit(len(w.pend))
+}
+
+func (w *ScreenPassthroughWri) Close() error {
+	return w.Flush()
+}
+
+// sends the first `n` bytes of `pend` as passthrough sequences
+func (w *ScreenPassthroughWri) emit(n int) error {
+
+	if n == 0 {
+		return nil
+	}
+
+	data := w.pend[:n]
+	tmp := make([]byte, 0, n+((n/w.nChunkSize)+2)*4)
+
+	for ix := 0; ix < len(data); {
+
+		end := ix + w.nChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		// END CHUNK BETWEEN ESC & \
+		if jx := bytes.Index(data[ix:end], []byte("\x1b\\")); jx >= 0 {
+			end = ix + jx + 1
+		}
+
+		tmp = append(tmp, SCREEN_PASSTHROUGH_HDR...)
+		tmp = append(tmp, data[ix:end]...)
+		tmp = append(tmp, SCREEN_PASSTHROUGH_FTR...)
+		ix = end
+	}
+
+	w.pend = append(w.pend[:0], w.pend[n:]...)
+	w.nBound -= n
+	if w.nBound < 0 {
+		w.nBound = 0
+	}
+
+	_, E := w.iWri.Write(tmp)
+	return E
+}
+
+// checks if running inside GNU screen (and not tmux)
+func IsScreen() bool {
+
+	if IsTmux() {
+		return false
+	}
+
+	return (len(os.Getenv("STY")) > 0) || IsTmuxScreen()
+}
+
+/*
+Returns a writer that delivers escape sequences to the outer terminal:
+a TmuxPassthroughWri inside tmux, a ScreenPassthroughWri inside GNU
+screen, or `out` unchanged otherwise.  The Kitty, iTerm & Sixel writers
+work through it as-is; Flush either wrapper when done.
+
+RASTERM_TMUX_PASSTHROUGH=1 wraps for tmux even where $TMUX isn't set,
+e.g. over ssh from inside tmux; =0 never wraps for tmux.
+*/
+func PassthroughWriter(out io.Writer) io.Writer {
+
+	bTmux := IsTmux()
+	if O := EnvOverrides(); O.HasTmuxPassthrough {
+		bTmux = O.TmuxPassthrough
+	}
+
+	if bTmux {
+		return NewTmuxPassthroughWriter(out)
+	}
+
+	if IsScreen() {
+		return NewScreenPassthroughWriter(out)
+	}
+
+	return out
+}
@@ -0,0 +1,262 @@
+package rasterm
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// installs a fake `tmux` script on $PATH, answering with `script`
+func fakeTmux(pT *testing.T, script string) {
+
+	if runtime.GOOS == "windows" {
+		pT.Skip("needs sh")
+	}
+
+	dir := pT.TempDir()
+	fpath := filepath.Join(dir, "tmux")
+	if E := os.WriteFile(fpath, []byte("#!/bin/sh\n"+script+"\n"), 0755); E != nil {
+		pT.Fatal(E)
+	}
+
+	pT.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// A NEW TMUX: FORGET THE LAST ONE'S CLIENT
+	resetTmuxClient := func() {
+		tmuxMu.Lock()
+		tmuxCached = false
+		tmuxMu.Unlock()
+	}
+	resetTmuxClient()
+	pT.Cleanup(resetTmuxClient)
+}
+
+func TestTmuxPassthroughWriter(pT *testing.T) {
+
+	buf := new(bytes.Buffer)
+	w := NewTmuxPassthroughWriter(buf)
+
+	if E := KittyWriteImage(w, sixelTestImage(4, 4), KittyImgOpts{}); E != nil {
+		pT.Fatal(E)
+	}
+
+	plain := new(bytes.Buffer)
+	KittyWriteImage(plain, sixelTestImage(4, 4), KittyImgOpts{})
+	if inner, _ := tmuxUnwrap(pT, buf.Bytes()); !bytes.Equal(inner, plain.Bytes()) {
+		pT.Errorf("unwrapped output differs:\n%q\n%q", inner, plain.Bytes())
+	}
+}
+
+// inner data & number of passthrough sequences in `b`
+func tmuxUnwrap(pT *testing.T, b []byte) ([]byte, int) {
+
+	pT.Helper()
+
+	// EVERY PASSTHROUGH SEQUENCE W/ DOUBLED ESC
+	var inner []byte
+	n := 0
+	for len(b) > 0 {
+
+		n++
+
+		if !bytes.HasPrefix(b, []byte(TMUX_PASSTHROUGH_HDR)) {
+			pT.Fatalf("expected passthrough header: %q", b)
+		}
+		b = b[len(TMUX_PASSTHROUGH_HDR):]
+
+		for {
+			if (b[0] == 0x1b) && (b[1] == 0x1b) {
+				inner = append(inner, 0x1b)
+				b = b[2:]
+			} else if (b[0] == 0x1b) && (b[1] == '\\') {
+				b = b[2:]
+				break
+			} else {
+				inner = append(inner, b[0])
+				b = b[1:]
+			}
+		}
+	}
+
+	return inner, n
+}
+
+func TestTmuxPassthroughBoundaries(pT *testing.T) {
+
+	// SIXEL IS WRITTEN TOKEN BY TOKEN: STILL ONE SEQUENCE
+	buf := new(bytes.Buffer)
+	w := NewTmuxPassthroughWriter(buf)
+	if E := SixelWriteImage(w, sixelTestImage(300, 40)); E != nil {
+		pT.Fatal(E)
+	}
+
+	plain := new(bytes.Buffer)
+	SixelWriteImage(plain, sixelTestImage(300, 40))
+	if inner, n := tmuxUnwrap(pT, buf.Bytes()); (n != 1) || !bytes.Equal(inner, plain.Bytes()) {
+		pT.Errorf("expected sixel in 1 passthrough sequence, got %d", n)
+	}
+
+	// PARTIAL SEQUENCES WAIT FOR THE REST, OR FLUSH
+	buf.Reset()
+	w.Write([]byte("ab\x1b["))
+	if inner, _ := tmuxUnwrap(pT, buf.Bytes()); string(inner) != "ab" {
+		pT.Fatalf("expected partial sequence held: %q", inner)
+	}
+	buf.Reset()
+	w.Write([]byte("2J\x1b]0;title\x07\x1b_Gx"))
+	if inner, n := tmuxUnwrap(pT, buf.Bytes()); (n != 1) || (string(inner) != "\x1b[2J\x1b]0;title\x07") {
+		pT.Errorf("expected complete sequences: %d, %q", n, inner)
+	}
+	buf.Reset()
+	w.Close()
+	if inner, _ := tmuxUnwrap(pT, buf.Bytes()); string(inner) != "\x1b_Gx" {
+		pT.Errorf("expected remainder on close: %q", inner)
+	}
+
+	// LARGE PAYLOADS: SPLIT BELOW THE LIMIT
+	buf.Reset()
+	big := append([]byte("\x1b_Ga=T;"), bytes.Repeat([]byte("QUJD"), TMUX_PASSTHROUGH_MAX/2)...)
+	big = append(big, "\x1b\\"...)
+	for ix := 0; ix < len(big); ix += 1000 {
+		end := ix + 1000
+		if end > len(big) {
+			end = len(big)
+		}
+		w.Write(big[ix:end])
+	}
+	b := buf.Bytes()
+	for len(b) > 0 {
+		jx := bytes.Index(b[1:], []byte(TMUX_PASSTHROUGH_HDR)) + 1
+		if jx == 0 {
+			jx = len(b)
+		}
+		if jx > TMUX_PASSTHROUGH_MAX+len(TMUX_PASSTHROUGH_HDR)+len(TMUX_PASSTHROUGH_FTR) {
+			pT.Fatalf("passthrough sequence of %d bytes", jx)
+		}
+		b = b[jx:]
+	}
+	if inner, n := tmuxUnwrap(pT, buf.Bytes()); (n < 2) || !bytes.Equal(inner, big) {
+		pT.Errorf("large payload: %d sequences, equal: %v", n, bytes.Equal(inner, big))
+	}
+}
+
+func TestTmuxPassthroughEnabled(pT *testing.T) {
+
+	tbl := []struct {
+		script string
+		bOn    bool
+		bErr   bool
+	}{
+		{"echo on", true, false},
+		{"echo all", true, false},
+		{"echo off", false, false},
+		{"echo 'invalid option: allow-passthrough' >&2; exit 1", true, false},
+		{"echo 'no server running' >&2; exit 1", false, true},
+	}
+
+	for _, t := range tbl {
+		fakeTmux(pT, t.script)
+		bOn, E := IsTmuxPassthroughEnabled()
+		if (bOn != t.bOn) || ((E != nil) != t.bErr) {
+			pT.Errorf("%q: got %v, %v", t.script, bOn, E)
+		}
+	}
+
+	pT.Setenv("TMUX", "")
+	if _, bOK := PassthroughWriter(os.Stdout).(*TmuxPassthroughWri); bOK {
+		pT.Error("wrapped outside tmux")
+	}
+
+	pT.Setenv("TMUX", "/tmp/tmux-1000/default,3218,4")
+	if _, bOK := PassthroughWriter(os.Stdout).(*TmuxPassthroughWri); !bOK {
+		pT.Error("not wrapped inside tmux")
+	}
+}
+
+/*
+What the outer terminal gets from GNU screen for `b`, per screen's DCS
+parsing: after ESC P, an ESC followed by \ ends the string, any other
+ESC is kept literally; the string is then passed on as-is.  Also
+returns the longest string.
+*/
+func screenUnwrap(pT *testing.T, b []byte) ([]byte, int) {
+
+	var inner []byte
+	nMax := 0
+	for len(b) > 0 {
+
+		if !bytes.HasPrefix(b, []byte(SCREEN_PASSTHROUGH_HDR)) {
+			pT.Fatalf("expected passthrough header: %q", b)
+		}
+		b = b[len(SCREEN_PASSTHROUGH_HDR):]
+
+		var str []byte
+		for {
+			if len(b) == 0 {
+				pT.Fatal("unterminated passthrough sequence")
+			}
+			if (b[0] == 0x1b) && (len(b) > 1) && (b[1] == '\\') {
+				b = b[2:]
+				break
+			}
+			str, b = append(str, b[0]), b[1:]
+		}
+
+		if len(str) > nMax {
+			nMax = len(str)
+		}
+		inner = append(inner, str...)
+	}
+
+	return inner, nMax
+}
+
+func TestScreenPassthroughWriter(pT *testing.T) {
+
+	plain, buf := new(bytes.Buffer), new(bytes.Buffer)
+	SixelWriteImage(plain, sixelTestImage(300, 40))
+
+	w := NewScreenPassthroughWriter(buf)
+	if E := SixelWriteImage(w, sixelTestImage(300, 40)); E != nil {
+		pT.Fatal(E)
+	}
+	if E := w.Flush(); E != nil {
+		pT.Fatal(E)
+	}
+
+	if inner, nMax := screenUnwrap(pT, buf.Bytes()); !bytes.Equal(inner, plain.Bytes()) || (nMax > SCREEN_CHUNK_SIZE) {
+		pT.Errorf("unwrapped output differs, or chunk of %d bytes:\n%q\n%q", nMax, inner, plain.Bytes())
+	}
+
+	// BYTE BY BYTE: NOTHING UNTIL THE SEQUENCE ENDS, THEN ITS ST SPLIT
+	// AS ESC ESC \ (LITERAL ESC, OUTER ST) & A CHUNK STARTING WITH \
+	buf.Reset()
+	for _, b := range []byte("\x1bPq#0!5~\x1b\\") {
+		if buf.Len() > 0 {
+			pT.Fatalf("sent before the sequence ended: %q", buf.String())
+		}
+		w.Write([]byte{b})
+	}
+	if s := buf.String(); s != "\x1bP\x1bPq#0!5~\x1b\x1b\\\x1bP\\\x1b\\" {
+		pT.Errorf("unexpected output: %q", s)
+	}
+
+	// PARTIAL SEQUENCE WAITS FOR Flush
+	buf.Reset()
+	w.Write([]byte("ab\x1b["))
+	if s := buf.String(); s != "\x1bPab\x1b\\" {
+		pT.Errorf("expected only the text: %q", s)
+	}
+	w.Close()
+	if inner, _ := screenUnwrap(pT, buf.Bytes()); string(inner) != "ab\x1b[" {
+		pT.Errorf("unexpected flushed output: %q", inner)
+	}
+
+	pT.Setenv("TMUX", "")
+	pT.Setenv("STY", "1234.pts-0.host")
+	if _, bOK := PassthroughWriter(os.Stdout).(*ScreenPassthroughWri); !bOK {
+		pT.Error("not wrapped inside screen")
+	}
+}
@@ -0,0 +1,116 @@
+package rasterm
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// A terminal query for Probe, identified by the reply it expects.
+type ProbeQuery struct {
+	Name    string
+	Request string
+	Reply   *regexp.Regexp
+}
+
+var (
+	rxKittyReply = regexp.MustCompile(`\x1b_Gi=31;([^\x1b]*)\x1b\\`)
+	rxOSC10      = regexp.MustCompile(`\x1b\]10;([^\x07\x1b]*)(?:\x07|\x1b\\)`)
+	rxOSC11      = regexp.MustCompile(`\x1b\]11;([^\x07\x1b]*)(?:\x07|\x1b\\)`)
+)
+
+var (
+	PROBE_XTVERSION      = ProbeQuery{"xtversion", "\x1b[>q", rxXTVERSION}
+	PROBE_DA2            = ProbeQuery{"da2", "\x1b[>c", rxDA2}
+	PROBE_KITTY          = ProbeQuery{"kitty", "\x1b_Gi=31,s=1,v=1,a=q,t=d,f=24;AAAA\x1b\\", rxKittyReply}
+	PROBE_SIXEL_COLORS   = ProbeQuery{"sixel_colors", "\x1b[?1;1;0S", regexp.MustCompile(`\x1b\[\?1;\d+;[\d;]*S`)}
+	PROBE_SIXEL_GEOMETRY = ProbeQuery{"sixel_geometry", "\x1b[?2;1;0S", regexp.MustCompile(`\x1b\[\?2;\d+;[\d;]*S`)}
+	PROBE_WINDOW_PIXELS  = ProbeQuery{"window_pixels", "\x1b[14t", regexp.MustCompile(`\x1b\[4;\d+;\d+t`)}
+	PROBE_CELL_PIXELS    = ProbeQuery{"cell_pixels", "\x1b[16t", regexp.MustCompile(`\x1b\[6;\d+;\d+t`)}
+	PROBE_TEXT_CELLS     = ProbeQuery{"text_cells", "\x1b[18t", regexp.MustCompile(`\x1b\[8;\d+;\d+t`)}
+	PROBE_FOREGROUND     = ProbeQuery{"foreground", "\x1b]10;?\x1b\\", rxOSC10}
+	PROBE_BACKGROUND     = ProbeQuery{"background", "\x1b]11;?\x1b\\", rxOSC11}
+	PROBE_SYNC_OUTPUT    = ProbeQuery{"sync_output", "\x1b[?2026$p", regexp.MustCompile(`\x1b\[\?2026;\d+\$y`)}
+
+	// Everything Detect asks for
+	PROBE_ALL = []ProbeQuery{
+		PROBE_XTVERSION,
+		PROBE_DA2,
+		PROBE_KITTY,
+		PROBE_SIXEL_COLORS,
+		PROBE_SIXEL_GEOMETRY,
+		PROBE_WINDOW_PIXELS,
+		PROBE_CELL_PIXELS,
+		PROBE_TEXT_CELLS,
+		PROBE_FOREGROUND,
+		PROBE_BACKGROUND,
+		PROBE_SYNC_OUTPUT,
+	}
+)
+
+type ProbeOpts struct {
+	Queries []ProbeQuery
+
+	// Wrap the batch in tmux passthrough so the outer terminal answers.
+	TmuxPassthrough bool
+}
+
+// Replies collected by Probe.
+type ProbeResult struct {
+	Replies map[string][]byte // by ProbeQuery.Name; absent if unanswered
+	DA1     []int             // primary device attributes
+}
+
+// Query `name` was answered.
+func (r *ProbeResult) Has(name string) bool {
+	_, bOK := r.Replies[name]
+	return bOK
+}
+
+/*
+Sends all queries at once, followed by primary DA, which every terminal
+answers.  Replies arrive in order, so once the DA1 reply is in, any
+query without a reply is unsupported.  One round trip instead of one
+(possibly timed-out) round trip per query.
+
+Returns E_TIMED_OUT if even DA1 goes unanswered.
+*/
+func (t *TermIO) Probe(ctx context.Context, opts ProbeOpts) (*ProbeResult, error) {
+
+	sb := strings.Builder{}
+	for _, q := range opts.Queries {
+		sb.WriteString(q.Request)
+	}
+	sb.WriteString("\x1b[0c")
+
+	sRq := sb.String()
+	if opts.TmuxPassthrough {
+		sRq = tmuxWrapQuery(sRq)
+	}
+
+	rsp, E := t.Query(ctx, sRq, TermQueryOpts{Terminator: RX_TERM_DA1})
+	if E != nil {
+		return nil, E
+	}
+
+	return parseProbe(rsp, opts.Queries), nil
+}
+
+func parseProbe(rsp []byte, queries []ProbeQuery) *ProbeResult {
+
+	R := &ProbeResult{Replies: make(map[string][]byte)}
+
+	// SENTINEL REPLY ENDS THE BATCH
+	if loc := RX_TERM_DA1.FindIndex(rsp); loc != nil {
+		R.DA1 = parseNumbers(rsp[loc[0]:loc[1]])
+		rsp = rsp[:loc[0]]
+	}
+
+	for _, q := range queries {
+		if m := q.Reply.Find(rsp); m != nil {
+			R.Replies[q.Name] = m
+		}
+	}
+
+	return R
+}
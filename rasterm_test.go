@@ -156,17 +156,21 @@ func testEx(iLog TestLogger, iWri io.Writer, mode string, testFiles []string) er
 func TestSixel(pT *testing.T) {
 
 	// NOTE: go test captures stdin/stdout to where they are no longer TTYs.
-	// This prevents IsSixelCapable() from operating, so always attempting
-	// sixels from the test, whether the terminal is capable or not.
 	// https://github.com/golang/go/issues/18153
+	// IsSixelCapable() queries the controlling terminal instead, so skip
+	// only when it answers no.  Without one, always attempt sixels.
 
-	// bSix, err := IsSixelCapable()
-	// if err != nil {
-	// 	pT.Fatal(err)
-	// }
+	if bSix, E := IsSixelCapable(); (E == nil) && !bSix {
+		pT.SkipNow()
+	}
+
+	fOut, E := OutputTTY(TTY_MODE)
+	if E != nil {
+		pT.Fatal(E)
+	}
 
 	fmt.Println("SIXEL")
-	if E := testEx(pT, os.Stdout, "sixel", testFiles); E != nil {
+	if E := testEx(pT, fOut, "sixel", testFiles); E != nil {
 		pT.Fatal(E)
 	}
 }
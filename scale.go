@@ -0,0 +1,429 @@
+package rasterm
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// How an image fits a target size, like CSS object-fit.
+type FitMode int
+
+const (
+	FIT_CONTAIN FitMode = iota // scaled to fit inside, aspect ratio kept
+	FIT_COVER                  // scaled to cover, aspect ratio kept, overflow cropped
+	FIT_FILL                   // stretched to the target size
+	FIT_NONE                   // natural size, overflow cropped
+)
+
+type Resampler int
+
+const (
+	// Nearest neighbor for paletted images scaled up, snapped to whole
+	// multiples by FIT_CONTAIN so pixel art stays crisp & paletted.
+	// Catmull-Rom otherwise.
+	RESAMPLE_AUTO Resampler = iota
+
+	RESAMPLE_NEAREST
+	RESAMPLE_BILINEAR
+	RESAMPLE_CATMULL_ROM
+	RESAMPLE_LANCZOS // Lanczos-3: sharpest, slowest
+)
+
+type ScaleOpts struct {
+	// Target size in pixels.  With one of them zero, the other follows
+	// from the image's aspect ratio & the given one is met exactly
+	// (FIT_CONTAIN pixel art aside).  Both zero: no scaling.
+	Width  int
+	Height int
+
+	// Target size in terminal cells, by the cell size in Geometry (or a
+	// typical one).  Take precedence over Width & Height.
+	Cols     int
+	Rows     int
+	Geometry TermGeometry
+
+	Fit       FitMode
+	Resampler Resampler
+}
+
+// target size in pixels, 0 where unset
+func (o ScaleOpts) target() (int, int) {
+
+	w, h := o.Width, o.Height
+	cw, ch := cellSize(o.Geometry)
+
+	if o.Cols > 0 {
+		w = o.Cols * cw
+	}
+	if o.Rows > 0 {
+		h = o.Rows * ch
+	}
+
+	return w, h
+}
+
+/*
+Scales `iImg` to the target size per opts.Fit.  FIT_CONTAIN may return
+a smaller image than the target; the others return exactly the target
+size.  Returns `iImg` itself when no scaling is needed.
+
+Paletted images scaled with nearest neighbor stay paletted.  Others
+come back as *image.RGBA.
+*/
+func Scale(iImg image.Image, opts ScaleOpts) image.Image {
+
+	_, bPal := iImg.(*image.Paletted)
+	rw, rh, w, h, bOK := opts.sizes(iImg.Bounds(), bPal)
+	if !bOK {
+		return iImg
+	}
+
+	return cropCenter(resize(iImg, rw, rh, opts.Resampler), w, h)
+}
+
+// Size of Scale's result for an image with bounds `b`.
+func (o ScaleOpts) Size(b image.Rectangle, bPaletted bool) (int, int) {
+
+	if _, _, w, h, bOK := o.sizes(b, bPaletted); bOK {
+		return w, h
+	}
+
+	return b.Dx(), b.Dy()
+}
+
+// size to resample to, then crop to, or false for no scaling
+func (o ScaleOpts) sizes(b image.Rectangle, bPal bool) (rw, rh, w, h int, bOK bool) {
+
+	tw, th := o.target()
+	if b.Empty() || ((tw <= 0) && (th <= 0)) {
+		return
+	}
+
+	sw, sh := b.Dx(), b.Dy()
+	bOK = true
+
+	// ONE SIDE GIVEN: KEPT EXACTLY, THE OTHER FOLLOWS
+	if (tw <= 0) || (th <= 0) {
+
+		s := float64(tw) / float64(sw)
+		if tw <= 0 {
+			s = float64(th) / float64(sh)
+		}
+
+		if o.Fit == FIT_NONE {
+			w = max1(int(math.Round(float64(sw) * s)))
+			h = max1(int(math.Round(float64(sh) * s)))
+			return sw, sh, minInt(sw, w), minInt(sh, h), bOK
+		}
+
+		if bPal && (o.Resampler == RESAMPLE_AUTO) && (o.Fit == FIT_CONTAIN) && (s >= 1) {
+			s = math.Floor(s)
+			tw, th = 0, 0
+		}
+
+		rw, rh = tw, th
+		if rw <= 0 {
+			rw = max1(int(math.Round(float64(sw) * s)))
+		}
+		if rh <= 0 {
+			rh = max1(int(math.Round(float64(sh) * s)))
+		}
+		return rw, rh, rw, rh, bOK
+	}
+
+	switch o.Fit {
+	case FIT_FILL:
+
+		return tw, th, tw, th, bOK
+
+	case FIT_NONE:
+
+		return sw, sh, minInt(sw, tw), minInt(sh, th), bOK
+
+	case FIT_COVER:
+
+		s := math.Max(float64(tw)/float64(sw), float64(th)/float64(sh))
+		rw = max1(int(math.Ceil(float64(sw)*s - 0.001)))
+		rh = max1(int(math.Ceil(float64(sh)*s - 0.001)))
+		return rw, rh, minInt(rw, tw), minInt(rh, th), bOK
+	}
+
+	// FIT_CONTAIN
+	s := math.Min(float64(tw)/float64(sw), float64(th)/float64(sh))
+
+	// PIXEL ART: WHOLE MULTIPLES ONLY
+	if bPal && (o.Resampler == RESAMPLE_AUTO) && (s >= 1) {
+		s = math.Floor(s)
+	}
+
+	rw = max1(int(math.Round(float64(sw) * s)))
+	rh = max1(int(math.Round(float64(sh) * s)))
+	return rw, rh, rw, rh, bOK
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// centered w x h view of `iImg`, or `iImg` if it already fits
+func cropCenter(iImg image.Image, w, h int) image.Image {
+
+	b := iImg.Bounds()
+	if (b.Dx() <= w) && (b.Dy() <= h) {
+		return iImg
+	}
+
+	x0 := b.Min.X + (b.Dx()-w)/2
+	y0 := b.Min.Y + (b.Dy()-h)/2
+	r := image.Rect(x0, y0, x0+w, y0+h).Intersect(b)
+
+	if si, bOK := iImg.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); bOK {
+		return si.SubImage(r)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Rect, iImg, r.Min, draw.Src)
+	return dst
+}
+
+// `iImg` resampled to exactly w x h
+func resize(iImg image.Image, w, h int, rs Resampler) image.Image {
+
+	b := iImg.Bounds()
+	if (w == b.Dx()) && (h == b.Dy()) {
+		return iImg
+	}
+
+	pI, bPal := iImg.(*image.Paletted)
+	bUp := (w >= b.Dx()) && (h >= b.Dy())
+
+	if rs == RESAMPLE_AUTO {
+		rs = RESAMPLE_CATMULL_ROM
+		if bPal && bUp {
+			rs = RESAMPLE_NEAREST
+		}
+	}
+
+	if rs == RESAMPLE_NEAREST {
+		if bPal {
+			return resizeNearestPaletted(pI, w, h)
+		}
+		return resizeNearest(toRGBA(iImg), w, h)
+	}
+
+	return resizeFilter(toRGBA(iImg), w, h, resampleFilters[rs])
+}
+
+// `iImg` as *image.RGBA with bounds at the origin, copied if need be
+func toRGBA(iImg image.Image) *image.RGBA {
+
+	if p, bOK := iImg.(*image.RGBA); bOK && (p.Rect.Min == image.Point{}) {
+		return p
+	}
+
+	b := iImg.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, iImg, b.Min, draw.Src)
+	return dst
+}
+
+// source index for destination index `i` of `n`, from `sn`
+func nearestIx(i, n, sn int) int {
+	return (2*i + 1) * sn / (2 * n)
+}
+
+func resizeNearestPaletted(src *image.Paletted, w, h int) *image.Paletted {
+
+	b := src.Rect
+	dst := image.NewPaletted(image.Rect(0, 0, w, h), src.Palette)
+
+	for y := 0; y < h; y++ {
+		sRow := src.Pix[(nearestIx(y, h, b.Dy()))*src.Stride:]
+		dRow := dst.Pix[y*dst.Stride:]
+		for x := 0; x < w; x++ {
+			dRow[x] = sRow[nearestIx(x, w, b.Dx())]
+		}
+	}
+
+	return dst
+}
+
+func resizeNearest(src *image.RGBA, w, h int) *image.RGBA {
+
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		sRow := src.Pix[nearestIx(y, h, sh)*src.Stride:]
+		dRow := dst.Pix[y*dst.Stride:]
+		for x := 0; x < w; x++ {
+			sx := nearestIx(x, w, sw) * 4
+			copy(dRow[x*4:x*4+4], sRow[sx:sx+4])
+		}
+	}
+
+	return dst
+}
+
+// separable resampling kernel, zero outside [-support, support]
+type resampleFilter struct {
+	support float64
+	kernel  func(x float64) float64
+}
+
+var resampleFilters = map[Resampler]resampleFilter{
+
+	RESAMPLE_BILINEAR: {1, func(x float64) float64 {
+		return 1 - math.Abs(x)
+	}},
+
+	// CUBIC, B = 0, C = 1/2
+	RESAMPLE_CATMULL_ROM: {2, func(x float64) float64 {
+		x = math.Abs(x)
+		if x < 1 {
+			return (1.5*x-2.5)*x*x + 1
+		}
+		return ((-0.5*x+2.5)*x-4)*x + 2
+	}},
+
+	RESAMPLE_LANCZOS: {3, func(x float64) float64 {
+		return sinc(x) * sinc(x/3)
+	}},
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+type resampleTap struct {
+	ix int
+	w  float32
+}
+
+/*
+Taps for each of `n` destination pixels from `sn` source pixels.  When
+shrinking, the kernel widens to cover every source pixel.
+*/
+func resampleTaps(sn, n int, f resampleFilter) [][]resampleTap {
+
+	scale := float64(sn) / float64(n)
+	fscale := math.Max(scale, 1)
+	support := f.support * fscale
+
+	sTaps := make([][]resampleTap, n)
+	for i := range sTaps {
+
+		center := (float64(i) + 0.5) * scale
+		lo := int(math.Floor(center - support))
+		hi := int(math.Ceil(center + support))
+
+		var sum float64
+		taps := make([]resampleTap, 0, hi-lo)
+		for j := lo; j < hi; j++ {
+
+			wt := f.kernel((float64(j) + 0.5 - center) / fscale)
+			if (wt == 0) || (math.Abs(float64(j)+0.5-center) >= support) {
+				continue
+			}
+
+			// EDGES REPEAT
+			jc := j
+			if jc < 0 {
+				jc = 0
+			} else if jc >= sn {
+				jc = sn - 1
+			}
+
+			taps = append(taps, resampleTap{jc, float32(wt)})
+			sum += wt
+		}
+
+		if sum != 0 {
+			for k := range taps {
+				taps[k].w /= float32(sum)
+			}
+		}
+
+		sTaps[i] = taps
+	}
+
+	return sTaps
+}
+
+// two-pass convolution on premultiplied pixels
+func resizeFilter(src *image.RGBA, w, h int, f resampleFilter) *image.RGBA {
+
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+
+	// HORIZONTAL: sw x sh => w x sh
+	tmp := make([]float32, w*sh*4)
+	xTaps := resampleTaps(sw, w, f)
+	for y := 0; y < sh; y++ {
+		sRow := src.Pix[y*src.Stride:]
+		tRow := tmp[y*w*4:]
+		for x, taps := range xTaps {
+			var r, g, b, a float32
+			for _, t := range taps {
+				p := sRow[t.ix*4:]
+				r += float32(p[0]) * t.w
+				g += float32(p[1]) * t.w
+				b += float32(p[2]) * t.w
+				a += float32(p[3]) * t.w
+			}
+			tRow[x*4], tRow[x*4+1], tRow[x*4+2], tRow[x*4+3] = r, g, b, a
+		}
+	}
+
+	// VERTICAL: w x sh => w x h
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	yTaps := resampleTaps(sh, h, f)
+	for y, taps := range yTaps {
+		dRow := dst.Pix[y*dst.Stride:]
+		for x := 0; x < w; x++ {
+			var r, g, b, a float32
+			for _, t := range taps {
+				p := tmp[(t.ix*w+x)*4:]
+				r += p[0] * t.w
+				g += p[1] * t.w
+				b += p[2] * t.w
+				a += p[3] * t.w
+			}
+
+			// RINGING MAY OVERSHOOT: KEEP PREMULTIPLIED VALUES VALID
+			A := clamp8(a)
+			dRow[x*4+3] = A
+			dRow[x*4] = min8(clamp8(r), A)
+			dRow[x*4+1] = min8(clamp8(g), A)
+			dRow[x*4+2] = min8(clamp8(b), A)
+		}
+	}
+
+	return dst
+}
+
+func clamp8(v float32) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+func min8(a, b uint8) uint8 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,175 @@
+package rasterm
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"testing"
+)
+
+func TestScaleFit(pT *testing.T) {
+
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+
+	type tcase struct {
+		opts ScaleOpts
+		w, h int
+	}
+
+	sTests := []tcase{
+		{ScaleOpts{}, 200, 100},
+		{ScaleOpts{Width: 100}, 100, 50},
+		{ScaleOpts{Height: 25}, 50, 25},
+		{ScaleOpts{Width: 100, Height: 100}, 100, 50},
+		{ScaleOpts{Width: 100, Height: 100, Fit: FIT_COVER}, 100, 100},
+		{ScaleOpts{Width: 100, Height: 100, Fit: FIT_FILL}, 100, 100},
+		{ScaleOpts{Width: 100, Height: 100, Fit: FIT_NONE}, 100, 100},
+		{ScaleOpts{Width: 400, Height: 400, Fit: FIT_NONE}, 200, 100},
+		{ScaleOpts{Width: 400, Height: 400}, 400, 200},
+
+		// 10 x 20 PX CELLS
+		{ScaleOpts{Cols: 5, Geometry: TermGeometry{CellWidth: 10, CellHeight: 20}}, 50, 25},
+		{ScaleOpts{Cols: 5, Rows: 1, Geometry: TermGeometry{CellWidth: 10, CellHeight: 20}}, 40, 20},
+		{ScaleOpts{Cols: 5, Width: 1000}, 5 * defaultCellWidth, 5 * defaultCellWidth / 2},
+	}
+
+	for _, t := range sTests {
+		for _, rs := range []Resampler{RESAMPLE_AUTO, RESAMPLE_NEAREST, RESAMPLE_BILINEAR, RESAMPLE_CATMULL_ROM, RESAMPLE_LANCZOS} {
+			t.opts.Resampler = rs
+			b := Scale(img, t.opts).Bounds()
+			if (b.Dx() != t.w) || (b.Dy() != t.h) {
+				pT.Errorf("%+v: expected %dx%d, got %dx%d", t.opts, t.w, t.h, b.Dx(), b.Dy())
+			}
+		}
+	}
+}
+
+func TestScaleResamplers(pT *testing.T) {
+
+	// UNIFORM COLOR STAYS UNIFORM, UP OR DOWN
+	img := image.NewRGBA(image.Rect(3, 5, 40, 30))
+	fill := color.RGBA{200, 100, 50, 255}
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			img.SetRGBA(x, y, fill)
+		}
+	}
+
+	for _, rs := range []Resampler{RESAMPLE_NEAREST, RESAMPLE_BILINEAR, RESAMPLE_CATMULL_ROM, RESAMPLE_LANCZOS} {
+		for _, w := range []int{7, 111} {
+			out := Scale(img, ScaleOpts{Width: w, Fit: FIT_FILL, Height: 13, Resampler: rs})
+			b := out.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					if c := color.RGBAModel.Convert(out.At(x, y)); c != fill {
+						pT.Fatalf("resampler %d, width %d: (%d,%d) = %v", rs, w, x, y, c)
+					}
+				}
+			}
+		}
+	}
+
+	// HARD EDGE: CATMULL-ROM & LANCZOS RING, BUT STAY VALID PREMULTIPLIED
+	edge := image.NewRGBA(image.Rect(0, 0, 8, 1))
+	for x := 4; x < 8; x++ {
+		edge.SetRGBA(x, 0, color.RGBA{255, 255, 255, 255})
+	}
+	for _, rs := range []Resampler{RESAMPLE_CATMULL_ROM, RESAMPLE_LANCZOS} {
+		out := Scale(edge, ScaleOpts{Width: 37, Height: 1, Fit: FIT_FILL, Resampler: rs}).(*image.RGBA)
+		for ix := 0; ix < len(out.Pix); ix += 4 {
+			if p := out.Pix[ix : ix+4]; (p[0] > p[3]) || (p[1] > p[3]) || (p[2] > p[3]) {
+				pT.Fatalf("resampler %d: invalid premultiplied pixel %v", rs, p)
+			}
+		}
+	}
+}
+
+func TestScalePixelArt(pT *testing.T) {
+
+	f, E := os.Open("./test_images/11.gif")
+	if E != nil {
+		pT.Fatal(E)
+	}
+	defer f.Close()
+
+	src, E := gif.Decode(f)
+	if E != nil {
+		pT.Fatal(E)
+	}
+	pSrc := src.(*image.Paletted)
+	sb := pSrc.Bounds()
+
+	// 3.5x FITS: SNAPPED TO 3x, STILL PALETTED
+	out := Scale(pSrc, ScaleOpts{Width: sb.Dx()*7/2 + 1, Height: sb.Dy() * 4})
+	pOut, bOK := out.(*image.Paletted)
+	if !bOK {
+		pT.Fatalf("expected *image.Paletted, got %T", out)
+	}
+	if b := pOut.Bounds(); (b.Dx() != 3*sb.Dx()) || (b.Dy() != 3*sb.Dy()) {
+		pT.Fatalf("expected %dx%d, got %dx%d", 3*sb.Dx(), 3*sb.Dy(), b.Dx(), b.Dy())
+	}
+
+	for y := 0; y < sb.Dy(); y++ {
+		for x := 0; x < sb.Dx(); x++ {
+			ix := pSrc.ColorIndexAt(sb.Min.X+x, sb.Min.Y+y)
+			for d := 0; d < 9; d++ {
+				if jx := pOut.ColorIndexAt(3*x+d%3, 3*y+d/3); jx != ix {
+					pT.Fatalf("(%d,%d): expected index %d, got %d", x, y, ix, jx)
+				}
+			}
+		}
+	}
+
+	// EXPLICIT RESAMPLER: EXACT FIT
+	out = Scale(pSrc, ScaleOpts{Width: sb.Dx() * 7 / 2, Resampler: RESAMPLE_BILINEAR})
+	if _, bOK := out.(*image.RGBA); !bOK || (out.Bounds().Dx() != sb.Dx()*7/2) {
+		pT.Errorf("expected RGBA %d wide, got %T %d wide", sb.Dx()*7/2, out, out.Bounds().Dx())
+	}
+}
+
+func TestScaleOneSide(pT *testing.T) {
+
+	type tcase struct {
+		w, h   int
+		opts   ScaleOpts
+		ew, eh int
+	}
+
+	// 8 x 16 PX CELLS BY DEFAULT
+	sTests := []tcase{
+		{1000, 10, ScaleOpts{Width: 140}, 140, 1},
+		{1000, 10, ScaleOpts{Height: 5}, 500, 5},
+		{1000, 10, ScaleOpts{Cols: 14}, 112, 1},
+		{1000, 10, ScaleOpts{Rows: 2}, 3200, 32},
+		{10, 1000, ScaleOpts{Width: 5}, 5, 500},
+		{10, 1000, ScaleOpts{Height: 140}, 1, 140},
+		{10, 1000, ScaleOpts{Cols: 2}, 16, 1600},
+		{10, 1000, ScaleOpts{Rows: 3}, 1, 48},
+	}
+
+	for _, t := range sTests {
+		for _, fit := range []FitMode{FIT_CONTAIN, FIT_COVER, FIT_FILL} {
+
+			t.opts.Fit = fit
+			b := image.Rect(0, 0, t.w, t.h)
+			if w, h := t.opts.Size(b, false); (w != t.ew) || (h != t.eh) {
+				pT.Errorf("%dx%d %+v: expected %dx%d, got %dx%d", t.w, t.h, t.opts, t.ew, t.eh, w, h)
+			}
+
+			if t.ew*t.eh > 100000 {
+				continue
+			}
+			if sb := Scale(image.NewRGBA(b), t.opts).Bounds(); (sb.Dx() != t.ew) || (sb.Dy() != t.eh) {
+				pT.Errorf("%dx%d %+v: scaled to %v", t.w, t.h, t.opts, sb.Size())
+			}
+		}
+	}
+
+	// THROUGH WriteImage: 2000 x 50 AT 13 COLUMNS OF 10 PX
+	g := TermGeometry{Cols: 80, Rows: 24, Width: 800, Height: 480, CellWidth: 10, CellHeight: 20}
+	img := image.NewRGBA(image.Rect(0, 0, 2000, 50))
+	if cols, rows := Footprint(img, PROTO_KITTY, g, WriteOpts{Cols: 13}); (cols != 13) || (rows != 1) {
+		pT.Errorf("expected 13x1, got %dx%d", cols, rows)
+	}
+}
@@ -1,9 +1,12 @@
 package rasterm
 
 import (
+	"context"
 	"fmt"
 	"image"
+	"image/color"
 	"io"
+	"sort"
 	"strconv"
 )
 
@@ -13,30 +16,189 @@ const (
 	SIXEL_MAX byte = 0x7e
 )
 
+/*
+Output compatibility settings for the sixel encoder.
+
+The zero value produces the same output as SIXEL_PROFILE_XTERM:
+7-bit controls, square pixels, RGB color specs, up to 256 color
+registers, and one unbroken line of sixel data per band.
+*/
+type SixelProfile struct {
+	Name string
+
+	// Vertical:horizontal pixel aspect ratio of the output device.
+	// 0 or 1 for square pixels, 2 for VT340-style 2:1 pixels.
+	// Source rows are sampled every PixelAspect rows so the image
+	// keeps its proportions on screen.
+	PixelAspect int
+
+	// Send HLS color specs (DEC hue, 0° = blue) instead of RGB.
+	HLSColors bool
+
+	// Number of color registers available.  0 means 256.
+	// Palette entries beyond this limit are mapped to the nearest
+	// color among the first MaxRegisters entries.
+	MaxRegisters int
+
+	// Use 8-bit C1 DCS (0x90) & ST (0x9C) instead of ESC P & ESC \.
+	C1Controls bool
+
+	// Insert a newline once a line of sixel data reaches this many
+	// bytes.  0 means never.  Newlines are ignored by sixel parsers,
+	// but keep serial links & line-oriented pipes happy.
+	LineWidth int
+
+	// Insert a newline after each Graphics NL (-).
+	BandNewline bool
+}
+
+var (
+	SIXEL_PROFILE_XTERM = SixelProfile{
+		Name: "xterm",
+	}
+	SIXEL_PROFILE_MLTERM = SixelProfile{
+		Name:        "mlterm",
+		BandNewline: true,
+	}
+	SIXEL_PROFILE_VT340 = SixelProfile{
+		Name:         "vt340",
+		PixelAspect:  2,
+		HLSColors:    true,
+		MaxRegisters: 16,
+		LineWidth:    79,
+		BandNewline:  true,
+	}
+	SIXEL_PROFILE_GENERIC = SixelProfile{
+		Name:        "generic",
+		LineWidth:   255,
+		BandNewline: true,
+	}
+)
+
+// Returns the named profile (xterm, mlterm, vt340, generic).
+func SixelProfileByName(name string) (SixelProfile, bool) {
+
+	for _, p := range []SixelProfile{
+		SIXEL_PROFILE_XTERM,
+		SIXEL_PROFILE_MLTERM,
+		SIXEL_PROFILE_VT340,
+		SIXEL_PROFILE_GENERIC,
+	} {
+		if p.Name == name {
+			return p, true
+		}
+	}
+
+	return SixelProfile{}, false
+}
+
+type SixelOpts struct {
+	Profile SixelProfile
+
+	// Trim blank leading & trailing sixels per color, pack colors into
+	// as few Graphics CR passes as possible, and drop unused color
+	// registers.  Smaller output, more encoding work.
+	Optimize bool
+
+	// If set, receives output size figures after encoding.
+	Stats *SixelStats
+
+	// Source rectangle to encode, relative to the image's Bounds().Min.
+	// Zero SrcWidth / SrcHeight extend to the image edge.  Clipped to
+	// the image bounds.  Like Kitty's x=, y=, w=, h=.
+	SrcX      int
+	SrcY      int
+	SrcWidth  int
+	SrcHeight int
+
+	// Move the cursor to this cell before drawing: 1-based, X = column,
+	// Y = row.  A zero (or negative) coordinate keeps the cursor's
+	// current column or row.
+	AtCell image.Point
+
+	// Where the cursor ends up, regardless of DECSDM or mode 8452
+	// behavior.  CURSOR_BELOW & CURSOR_BESIDE count cells by the cell
+	// size in Geometry.
+	Cursor CursorPos
+
+	// Cell size for Cursor.  Defaults to a typical one.
+	Geometry TermGeometry
+
+	// Color partially-transparent palette entries are blended onto.
+	// Defaults to black; Capabilities.Background is the terminal's.
+	Matte color.Color
+}
+
+// Source rectangle selected by SrcX, SrcY, SrcWidth & SrcHeight,
+// clipped to `bounds`.
+func (o SixelOpts) srcRect(bounds image.Rectangle) image.Rectangle {
+
+	r := bounds
+	r.Min = r.Min.Add(image.Pt(o.SrcX, o.SrcY))
+	if o.SrcWidth > 0 {
+		r.Max.X = r.Min.X + o.SrcWidth
+	}
+	if o.SrcHeight > 0 {
+		r.Max.Y = r.Min.Y + o.SrcHeight
+	}
+
+	return r.Intersect(bounds)
+}
+
+// Output size report, see SixelOpts.Stats.
+type SixelStats struct {
+	Bytes            int64 // bytes written
+	UnoptimizedBytes int64 // bytes the same image takes without SixelOpts.Optimize
+	Registers        int   // color registers defined
+}
+
+// Bytes saved by SixelOpts.Optimize.
+func (s SixelStats) Saved() int64 {
+	return s.UnoptimizedBytes - s.Bytes
+}
+
+// Checks primary device attributes for sixel support, unless
+// RASTERM_PROTOCOL says otherwise.
 func IsSixelCapable() (bool, error) {
+	return StdTermIO().IsSixelCapable(context.Background())
+}
 
-	sATT, E := RequestTermAttributes()
+// See IsSixelCapable.
+func (t *TermIO) IsSixelCapable(ctx context.Context) (bool, error) {
+
+	if p, bOK := protocolOverride(GetEnvIdentifiers()); bOK {
+		return p == PROTO_SIXEL, nil
+	}
+
+	sATT, E := t.RequestTermAttributes(ctx)
 	if E != nil {
 		return false, E
 	}
 
+	return isSixelDA1(sATT), nil
+}
+
+// checks primary device attributes for sixel support
+func isSixelDA1(sATT []int) bool {
+
 	for ix := range sATT {
 
 		// IGNORE `4` @ 1ST INDEX -- THAT IS TERMINAL ID RATHER THAN SIXEL SUPPORT
 		if (ix > 0) && (sATT[ix] == 4) {
-			return true, nil
+			return true
 		}
 	}
 
-	return false, nil
+	return false
 }
 
 /*
 Encodes a paletted image into DECSIXEL format.
 Forked & heavily modified from https://github.com/mattn/go-sixel/
 
-Since SIXEL does not support alpha transparency, any alpha > 0
-will be treated as fully opaque.
+Since SIXEL does not support alpha transparency, fully-transparent
+palette entries are left undrawn, and partially-transparent ones are
+blended onto opts.Matte.
 
 SIXEL is a paletted format.  To keep dependencies to a minimum, this only
 supports paletted images. Palette entries beyond index 255 are ignored.
@@ -47,64 +209,111 @@ For more information on DECSIXEL format:
 	https://www.vt100.net/docs/vt3xx-gp/chapter14.html
 	https://saitoha.github.io/libsixel/
 */
-func SixelWriteImage(out io.Writer, pI *image.Paletted) (E error) {
+func SixelWriteImage(out io.Writer, pI *image.Paletted) error {
+	return SixelWriteImageWithOptions(out, pI, SixelOpts{})
+}
+
+// Encodes a paletted image into DECSIXEL format using the output
+// profile in `opts`.  See SixelWriteImage.
+func SixelWriteImageWithOptions(out io.Writer, pI *image.Paletted, opts SixelOpts) error {
 
-	width, height := pI.Bounds().Dx(), pI.Bounds().Dy()
+	prof := opts.Profile
+
+	aspect := prof.PixelAspect
+	if aspect < 1 {
+		aspect = 1
+	}
+
+	nRegs := prof.MaxRegisters
+	if (nRegs <= 0) || (nRegs > 256) {
+		nRegs = 256
+	}
+
+	// VIEW OF SOURCE RECTANGLE, NO COPY
+	pI, _ = pI.SubImage(opts.srcRect(pI.Bounds())).(*image.Paletted)
+	bounds := pI.Bounds()
+
+	width, srcHeight := bounds.Dx(), bounds.Dy()
+	height := (srcHeight + aspect - 1) / aspect
 	if (width <= 0) || (height <= 0) {
-		return
+		return nil
 	}
 
 	if len(pI.Palette) == 0 {
-		return
+		return nil
 	}
 
-	OSC_OPEN, OSC_CLOSE := "\x1b", "\x1b\\"
+	DCS, ST := "\x1bP", "\x1b\\"
+	if prof.C1Controls {
+		DCS, ST = "\x90", "\x9c"
+	}
+
+	sw := sixelWri{iWri: out, nLineWidth: prof.LineWidth}
+
+	// SAME TOKENS, UNOPTIMIZED, COUNTED FOR Stats
+	var plain *sixelWri
+	if opts.Optimize && (opts.Stats != nil) {
+		plain = &sixelWri{iWri: io.Discard, nLineWidth: prof.LineWidth}
+	}
 
-	// CAPTURE WRITE ERROR FOR SIMPLIFIED CHECKING
-	fnWri := func(v []byte) error {
-		_, E = out.Write(v)
-		return E
+	// AT THE IMAGE'S TOP LEFT, SAVED TO PLACE THE CURSOR AFTER
+	sPre := sixelCellPos(opts.AtCell)
+	if opts.Cursor != CURSOR_PROTOCOL {
+		sPre += ESC_CURSOR_SAVE
 	}
 
-	// INTRODUCER = <ESC>P0;1q
+	if (sPre != "") && (sw.token([]byte(sPre)) != nil) {
+		return sw.E
+	}
+	if (sPre != "") && (plain != nil) {
+		plain.token([]byte(sPre))
+	}
+
+	// INTRODUCER = <DCS>0;1q
 	// 0; rely on RASTER ATTRIBUTES to set aspect ratio
 	// 1; palette[0] as opaque
-	// RASTER ATTRIBUTES (1:1 aspect ratio) = "1;1;width;height
-	_, E = fmt.Fprintf(out, "%sP0;1q\"1;1;%d;%d", OSC_OPEN, width, height)
-	if E != nil {
-		return
+	// RASTER ATTRIBUTES (aspect:1 ratio) = "aspect;1;width;height
+	sIntro := fmt.Sprintf("%s0;1q\"%d;1;%d;%d", DCS, aspect, width, height)
+	if sw.token([]byte(sIntro)) != nil {
+		return sw.E
 	}
+	if plain != nil {
+		plain.token([]byte(sIntro))
+	}
+
+	// MAP PALETTE INDICES TO COLOR REGISTERS, -1 FOR TRANSPARENT
+	regMap := sixelRegisterMap(pI.Palette, nRegs)
 
-	// CONVERT uint32 [0..0xFFFF] COLOR COMPONENT TO WHOLE PERCENTAGE
-	P := func(v uint32) uint8 {
-		return uint8(((v + 1) * 100) >> 16)
+	nColors := nRegs
+	if len(pI.Palette) < nColors {
+		nColors = len(pI.Palette)
 	}
 
-	// SEND PALETTE
-	for ix_color, v := range pI.Palette {
+	// REGISTER -> PALETTE INDEX OF ITS COLOR, -1 IF NOT SENT
+	regColor := make([]int, nColors)
+	for ix := range regColor {
+		regColor[ix] = regMap[ix]
+	}
 
-		// SIXEL ONLY SUPPORTS 256 COLORS
-		if ix_color > 255 {
-			break
-		}
+	if opts.Matte == nil {
+		opts.Matte = fallbackMatte
+	}
 
-		// R,G,B AS WHOLE PERCENTAGES
-		r, g, b, a := v.RGBA()
+	if plain != nil {
+		sixelWritePalette(plain, pI.Palette, regColor, opts.Matte, prof.HLSColors)
+	}
 
-		// OMIT FULLY-TRANSPARENT COLORS FROM GCI PALETTE
-		if a == 0 {
-			continue
-		}
+	// DROP UNUSED REGISTERS, RENUMBER THE REST CONTIGUOUSLY;
+	// regColor THEN HOLDS THE ORIGINAL REGISTERS
+	if opts.Optimize {
+		regMap, regColor = sixelCompactRegisters(pI, regMap, nColors, aspect, height)
+		nColors = len(regColor)
+	}
 
-		// DECGCI (#): Graphics Color Introducer
-		// SEE: https://www.vt100.net/docs/vt3xx-gp/chapter14.html
-		_, E = fmt.Fprintf(out, "#%d;2;%d;%d;%d", ix_color, P(r), P(g), P(b))
-		if E != nil {
-			return
-		}
+	if sixelWritePalette(&sw, pI.Palette, regColor, opts.Matte, prof.HLSColors) != nil {
+		return sw.E
 	}
 
-	nColors := len(pI.Palette)
 	color_used := make([]bool, nColors)
 	color_used_blank := make([]bool, nColors)
 	buf := make([]byte, width*nColors)
@@ -116,8 +325,17 @@ func SixelWriteImage(out io.Writer, pI *image.Paletted) (E error) {
 
 		// GRAPHICS NL (start a new sixel line)
 		if ix_srow > 0 {
-			if fnWri([]byte(`-`)) != nil {
-				return
+			if sw.token([]byte(`-`)) != nil {
+				return sw.E
+			}
+			if prof.BandNewline && (sw.newline() != nil) {
+				return sw.E
+			}
+			if plain != nil {
+				plain.token([]byte(`-`))
+				if prof.BandNewline {
+					plain.newline()
+				}
 			}
 		}
 
@@ -136,77 +354,465 @@ func SixelWriteImage(out io.Writer, pI *image.Paletted) (E error) {
 
 			for x := 0; x < width; x++ {
 
-				color_ix := pI.ColorIndexAt(x, y)
-
 				// SKIP FULLY-TRANSPARENT PIXELS
-				_, _, _, a := pI.Palette[color_ix].RGBA()
-				if a == 0 {
+				reg := regMap[pI.ColorIndexAt(bounds.Min.X+x, bounds.Min.Y+(y*aspect))]
+				if reg < 0 {
 					continue
 				}
 
-				color_used[color_ix] = true
-				buf[(width*int(color_ix))+x] |= 1 << uint(p)
+				color_used[reg] = true
+				buf[(width*reg)+x] |= 1 << uint(p)
 			}
 		}
 
-		// RENDER SIXEL ROW FOR EACH PALETTE ENTRY
-		bFirstColorWritten := false
-		for n := 0; n < nColors; n++ {
+		passes := sixelPlanPasses(buf, color_used, width, opts.Optimize)
+		if sixelWriteRow(&sw, passes, buf, width, nil) != nil {
+			return sw.E
+		}
+		if plain != nil {
+			sixelWriteRow(plain, sixelPlanPasses(buf, color_used, width, false), buf, width, regColor)
+		}
+	}
+
+	// SIXEL TERMINATOR
+	if sw.token([]byte(ST)) != nil {
+		return sw.E
+	}
+	if plain != nil {
+		plain.token([]byte(ST))
+	}
 
-			if !color_used[n] {
-				continue
+	// EACH SIXEL ROW IS `aspect` PIXELS HIGH, IN BANDS OF 6
+	if opts.Cursor != CURSOR_PROTOCOL {
+		cols, rows := pixelCells(width, roundUp(height, 6)*aspect, opts.Geometry)
+		sPost := []byte(cursorAfter(opts.Cursor, cols, rows))
+		if sw.token(sPost) != nil {
+			return sw.E
+		}
+		if plain != nil {
+			plain.token(sPost)
+		}
+	}
+
+	if opts.Stats != nil {
+
+		*opts.Stats = SixelStats{
+			Bytes:            sw.nTotal,
+			UnoptimizedBytes: sw.nTotal,
+			Registers:        nColors,
+		}
+
+		if plain != nil {
+			opts.Stats.UnoptimizedBytes = plain.nTotal
+		}
+	}
+
+	return nil
+}
+
+// cursor to `at`, per SixelOpts.AtCell: CUP, or CHA / VPA for one axis
+func sixelCellPos(at image.Point) string {
+
+	switch {
+	case (at.X > 0) && (at.Y > 0):
+		return fmt.Sprintf("\x1b[%d;%dH", at.Y, at.X)
+	case at.X > 0:
+		return fmt.Sprintf("\x1b[%dG", at.X)
+	case at.Y > 0:
+		return fmt.Sprintf("\x1b[%dd", at.Y)
+	}
+
+	return ""
+}
+
+// DECGCI (#) color definitions for each register: its palette index in
+// `regColor`, -1 if not sent
+func sixelWritePalette(sw *sixelWri, pal color.Palette, regColor []int, matte color.Color, bHLS bool) error {
+
+	for reg, ix_color := range regColor {
+
+		if ix_color < 0 {
+			continue
+		}
+
+		// DECGCI (#): Graphics Color Introducer
+		// SEE: https://www.vt100.net/docs/vt3xx-gp/chapter14.html
+		c := pal[ix_color]
+		if _, _, _, a := c.RGBA(); a < 0xFFFF {
+			c = blendMatte(c, matte)
+		}
+
+		if sw.token(sixelColorSpec(reg, c, bHLS)) != nil {
+			return sw.E
+		}
+	}
+
+	return nil
+}
+
+/*
+One buffered sixel row, one Graphics CR (overwrite last line) per pass.
+Runs are introduced by their register, or by regNum[register] if given.
+*/
+func sixelWriteRow(sw *sixelWri, passes [][]sixelRun, buf []byte, width int, regNum []int) error {
+
+	for ix_pass, pass := range passes {
+
+		if ix_pass > 0 {
+			if sw.token([]byte(`$`)) != nil {
+				return sw.E
 			}
+		}
 
-			// GRAPHICS CR (overwrite last line w/ new color)
-			if bFirstColorWritten {
-				if fnWri([]byte(`$`)) != nil {
-					return
-				}
+		x := 0
+		for _, run := range pass {
+
+			reg := run.reg
+			if regNum != nil {
+				reg = regNum[reg]
 			}
 
 			// COLOR INTRODUCER (#)
 			tmpCI := make([]byte, 1, 4)
 			tmpCI[0] = byte('#')
-			tmpCI = strconv.AppendInt(tmpCI, int64(n), 10)
-			if fnWri(tmpCI) != nil {
-				return
+			tmpCI = strconv.AppendInt(tmpCI, int64(reg), 10)
+			if sw.token(tmpCI) != nil {
+				return sw.E
 			}
 
-			rleCt := 0
-			cPrev := byte(255)
-			for x := 0; x < width; x++ {
+			// SKIP TO START OF RUN WITH BLANK SIXELS
+			if sw.token(encodeGRI(run.x0-x, 0)) != nil {
+				return sw.E
+			}
+
+			off := run.reg * width
+			if sixelWriteRLE(sw, buf[off+run.x0:off+run.x1]) != nil {
+				return sw.E
+			}
+
+			x = run.x1
+		}
+	}
 
-				// GET BUFFERED SIXEL
-				cNext := buf[(n*width)+x]
+	return nil
+}
 
-				// RLE ENCODE, WRITE ON VALUE CHANGE
-				// USE 255 AS SENTINEL FOR INITIAL RUN
-				if (cPrev != 255) && (cNext != cPrev) {
+// RLE encode one row of buffered sixels
+func sixelWriteRLE(sw *sixelWri, row []byte) error {
 
-					if fnWri(encodeGRI(rleCt, cPrev)) != nil {
-						return
-					}
-					rleCt = 0
-				}
+	rleCt := 0
+	cPrev := byte(255)
+	for _, cNext := range row {
 
-				cPrev = cNext
-				rleCt++
+		// RLE ENCODE, WRITE ON VALUE CHANGE
+		// USE 255 AS SENTINEL FOR INITIAL RUN
+		if (cPrev != 255) && (cNext != cPrev) {
+
+			if sw.token(encodeGRI(rleCt, cPrev)) != nil {
+				return sw.E
 			}
+			rleCt = 0
+		}
+
+		cPrev = cNext
+		rleCt++
+	}
+
+	// WRITE LAST SIXEL IN LINE
+	return sw.token(encodeGRI(rleCt, cPrev))
+}
+
+// span [x0, x1) of a sixel row drawn in color register `reg`
+type sixelRun struct {
+	reg, x0, x1 int
+}
 
-			// WRITE LAST SIXEL IN LINE
-			if fnWri(encodeGRI(rleCt, cPrev)) != nil {
-				return
+/*
+Groups the used colors of a buffered sixel row into passes, each pass
+separated by a Graphics CR.
+
+Unoptimized, every used color spans the full width in its own pass.
+Optimized, each color is trimmed to its non-blank columns, and colors
+whose spans don't overlap share a pass (interval partitioning), which
+minimizes the number of carriage returns.
+*/
+func sixelPlanPasses(buf []byte, color_used []bool, width int, bOptimize bool) [][]sixelRun {
+
+	var runs []sixelRun
+	for reg, bUsed := range color_used {
+
+		if !bUsed {
+			continue
+		}
+
+		if !bOptimize {
+			runs = append(runs, sixelRun{reg, 0, width})
+			continue
+		}
+
+		row := buf[reg*width : (reg+1)*width]
+		x0, x1 := 0, width
+		for (x0 < x1) && (row[x0] == 0) {
+			x0++
+		}
+		for (x1 > x0) && (row[x1-1] == 0) {
+			x1--
+		}
+		if x0 < x1 {
+			runs = append(runs, sixelRun{reg, x0, x1})
+		}
+	}
+
+	if !bOptimize {
+		passes := make([][]sixelRun, len(runs))
+		for ix := range runs {
+			passes[ix] = runs[ix : ix+1]
+		}
+		return passes
+	}
+
+	// GREEDY BY START COLUMN: PLACE EACH RUN IN THE PASS THAT FREED UP
+	// EARLIEST, OR OPEN A NEW PASS IF NONE HAS ROOM
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].x0 < runs[j].x0
+	})
+
+	var passes [][]sixelRun
+	for _, run := range runs {
+
+		best := -1
+		for ix, pass := range passes {
+			end := pass[len(pass)-1].x1
+			if (end <= run.x0) && ((best < 0) || (end < passes[best][len(passes[best])-1].x1)) {
+				best = ix
 			}
+		}
 
-			bFirstColorWritten = true
+		if best < 0 {
+			passes = append(passes, []sixelRun{run})
+		} else {
+			passes[best] = append(passes[best], run)
 		}
 	}
 
-	// SIXEL TERMINATOR
-	fnWri([]byte(OSC_CLOSE))
+	return passes
+}
+
+/*
+Scans the image for registers that are actually drawn, then renumbers
+them contiguously from 0.  Returns the updated palette index ->
+register map, and the register -> palette index table.
+*/
+func sixelCompactRegisters(pI *image.Paletted, regMap []int, nColors, aspect, height int) ([]int, []int) {
+
+	bounds := pI.Bounds()
+	used := make([]bool, nColors)
+	for y := 0; y < height; y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			if reg := regMap[pI.ColorIndexAt(bounds.Min.X+x, bounds.Min.Y+(y*aspect))]; reg >= 0 {
+				used[reg] = true
+			}
+		}
+	}
+
+	renum := make([]int, nColors)
+	var regColor []int
+	for reg := range used {
+		renum[reg] = -1
+		if used[reg] {
+			renum[reg] = len(regColor)
+			regColor = append(regColor, reg)
+		}
+	}
+
+	newMap := make([]int, len(regMap))
+	for ix, reg := range regMap {
+		newMap[ix] = -1
+		if reg >= 0 {
+			newMap[ix] = renum[reg]
+		}
+	}
+
+	return newMap, regColor
+}
+
+/*
+Maps palette indices to color registers.  Fully-transparent entries
+map to -1.  Entries at or beyond `nRegs` map to the nearest opaque
+entry below `nRegs`.
+*/
+func sixelRegisterMap(pal color.Palette, nRegs int) []int {
+
+	regMap := make([]int, len(pal))
+
+	lim := nRegs
+	if len(pal) < lim {
+		lim = len(pal)
+	}
+
+	var sub color.Palette
+	var subIx []int
+	for ix, v := range pal {
+
+		_, _, _, a := v.RGBA()
+
+		// OMIT FULLY-TRANSPARENT COLORS FROM GCI PALETTE
+		if a == 0 {
+			regMap[ix] = -1
+			continue
+		}
+
+		regMap[ix] = ix
+		if ix < lim {
+			sub = append(sub, v)
+			subIx = append(subIx, ix)
+		}
+	}
+
+	for ix := lim; ix < len(pal); ix++ {
+		if regMap[ix] < 0 {
+			continue
+		}
+		if len(sub) == 0 {
+			regMap[ix] = -1
+			continue
+		}
+		regMap[ix] = subIx[sub.Index(pal[ix])]
+	}
+
+	return regMap
+}
+
+// DECGCI color definition for register `reg` in RGB or HLS form.
+func sixelColorSpec(reg int, v color.Color, bHLS bool) []byte {
+
+	r, g, b, _ := v.RGBA()
+
+	tmp := make([]byte, 0, 20)
+	tmp = append(tmp, '#')
+	tmp = strconv.AppendInt(tmp, int64(reg), 10)
+
+	if bHLS {
+
+		h, l, s := decHLS(r, g, b)
+		tmp = append(tmp, ";1;"...)
+		tmp = strconv.AppendInt(tmp, int64(h), 10)
+		tmp = append(tmp, ';')
+		tmp = strconv.AppendInt(tmp, int64(l), 10)
+		tmp = append(tmp, ';')
+		tmp = strconv.AppendInt(tmp, int64(s), 10)
+
+	} else {
+
+		// CONVERT uint32 [0..0xFFFF] COLOR COMPONENT TO WHOLE PERCENTAGE
+		P := func(v uint32) int64 {
+			return int64(((v + 1) * 100) >> 16)
+		}
+
+		tmp = append(tmp, ";2;"...)
+		tmp = strconv.AppendInt(tmp, P(r), 10)
+		tmp = append(tmp, ';')
+		tmp = strconv.AppendInt(tmp, P(g), 10)
+		tmp = append(tmp, ';')
+		tmp = strconv.AppendInt(tmp, P(b), 10)
+	}
+
+	return tmp
+}
+
+/*
+Converts 16-bit RGB components to DEC HLS: hue in degrees with blue
+at 0°, red at 120° & green at 240°; lightness & saturation as whole
+percentages.
+*/
+func decHLS(r, g, b uint32) (h, l, s int) {
+
+	fR, fG, fB := float64(r)/0xffff, float64(g)/0xffff, float64(b)/0xffff
+
+	cMax, cMin := fR, fR
+	for _, v := range []float64{fG, fB} {
+		if v > cMax {
+			cMax = v
+		}
+		if v < cMin {
+			cMin = v
+		}
+	}
+
+	fL := (cMax + cMin) / 2
+	l = int(fL*100 + 0.5)
+
+	d := cMax - cMin
+	if d == 0 {
+		return 0, l, 0
+	}
+
+	if fL > 0.5 {
+		s = int(d/(2-cMax-cMin)*100 + 0.5)
+	} else {
+		s = int(d/(cMax+cMin)*100 + 0.5)
+	}
+
+	var fH float64
+	switch cMax {
+	case fR:
+		fH = (fG - fB) / d
+		if fG < fB {
+			fH += 6
+		}
+	case fG:
+		fH = (fB-fR)/d + 2
+	default:
+		fH = (fR-fG)/d + 4
+	}
+
+	// ROTATE FROM RED @ 0° TO DEC'S BLUE @ 0°
+	h = (int(fH*60+0.5) + 120) % 360
 	return
 }
 
+// sixel data writer: captures the first error, optionally wraps lines
+type sixelWri struct {
+	iWri       io.Writer
+	nLineWidth int
+	nLine      int
+	nTotal     int64
+	E          error
+}
+
+// Write a token that must not be split across lines.
+func (w *sixelWri) token(v []byte) error {
+
+	if w.E != nil {
+		return w.E
+	}
+
+	if (w.nLineWidth > 0) && (w.nLine > 0) && ((w.nLine + len(v)) > w.nLineWidth) {
+		if w.newline() != nil {
+			return w.E
+		}
+	}
+
+	var n int
+	n, w.E = w.iWri.Write(v)
+	w.nLine += n
+	w.nTotal += int64(n)
+	return w.E
+}
+
+func (w *sixelWri) newline() error {
+
+	if w.E != nil {
+		return w.E
+	}
+
+	var n int
+	n, w.E = w.iWri.Write([]byte{'\n'})
+	w.nLine = 0
+	w.nTotal += int64(n)
+	return w.E
+}
+
 func encodeGRI(rleCt int, sixl byte) []byte {
 
 	if rleCt <= 0 {
@@ -0,0 +1,297 @@
+package rasterm
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"sort"
+	"time"
+)
+
+// One frame of a sixel animation.
+type SixelFrame struct {
+	Image *image.Paletted
+	Delay time.Duration // time to display this frame before the next
+}
+
+type SixelAnimOpts struct {
+	// Encoder options applied to every frame.
+	Sixel SixelOpts
+
+	// Terminal capabilities: synchronized output where Caps.SyncOutput,
+	// & the cell size for placing the cursor below the image.  Defaults
+	// to Detect's, found once per process, as for WriteImage.
+	Caps *Capabilities
+
+	// Wrap each frame in synchronized output mode (DECSET 2026) so
+	// terminals that support it draw frames without tearing, even where
+	// Caps doesn't report it.  Ignored by terminals that don't.
+	SyncOutput bool
+
+	// Color shown behind transparent GIF pixels.  Sixel redraws
+	// can't erase, so transparent areas are flattened onto this
+	// color.  Defaults to black; Capabilities.Background is the
+	// terminal's.
+	Matte color.Color
+}
+
+/*
+Plays frames from `frames` in place: the cursor position is saved
+before the first frame, and restored before each following frame.
+
+Returns when `frames` is closed (nil), or when `ctx` is cancelled
+(ctx.Err()).  Either way, the cursor is then moved to the row below the
+last frame drawn, at its left edge, by the cell size in opts.Caps.
+
+NOTE: the first frame should fit on screen without scrolling, otherwise
+the saved cursor position no longer points at the image.  Setting
+opts.Sixel.AtCell pins every frame to a fixed cell instead.
+*/
+func SixelAnimate(ctx context.Context, out io.Writer, frames <-chan SixelFrame, opts SixelAnimOpts) error {
+
+	if opts.Caps == nil {
+		if C, _ := cachedCapabilities(ctx); C != nil {
+			opts.Caps = C
+		} else {
+			opts.Caps = &Capabilities{}
+		}
+	}
+	opts.SyncOutput = opts.SyncOutput || opts.Caps.SyncOutput
+
+	var last *image.Paletted
+	for {
+
+		var frm SixelFrame
+		var bOK bool
+		select {
+		case <-ctx.Done():
+			return sixelAnimEnd(out, last, ctx.Err(), opts)
+		case frm, bOK = <-frames:
+			if !bOK {
+				return sixelAnimEnd(out, last, nil, opts)
+			}
+		}
+
+		if E := sixelDrawFrame(out, frm.Image, last == nil, opts); E != nil {
+			return E
+		}
+		last = frm.Image
+
+		tmr := time.NewTimer(frm.Delay)
+		select {
+		case <-ctx.Done():
+			tmr.Stop()
+			return sixelAnimEnd(out, last, ctx.Err(), opts)
+		case <-tmr.C:
+		}
+	}
+}
+
+// moves the cursor below the last frame, if any, then returns `E`
+func sixelAnimEnd(out io.Writer, last *image.Paletted, E error, opts SixelAnimOpts) error {
+
+	if last == nil {
+		return E
+	}
+
+	// FRAME TOP LEFT, THEN DOWN BY ITS 6-PIXEL BANDS
+	_, rows := pixelCells(0, roundUp(opts.Sixel.srcRect(last.Bounds()).Dy(), 6), opts.Caps.Geometry)
+	sPost := ESC_CURSOR_RESTORE + sixelCellPos(opts.Sixel.AtCell) + fmt.Sprintf("\x1b[%dB", max1(rows))
+
+	if _, EW := io.WriteString(out, sPost); EW != nil {
+		return EW
+	}
+
+	return E
+}
+
+func sixelDrawFrame(out io.Writer, pI *image.Paletted, bFirst bool, opts SixelAnimOpts) error {
+
+	sPre := ESC_CURSOR_RESTORE
+	if bFirst {
+		sPre = ESC_CURSOR_SAVE
+	}
+
+	if opts.SyncOutput {
+		sPre = ESC_SYNC_BEGIN + sPre
+	}
+
+	if _, E := io.WriteString(out, sPre); E != nil {
+		return E
+	}
+
+	if E := SixelWriteImageWithOptions(out, pI, opts.Sixel); E != nil {
+		return E
+	}
+
+	if opts.SyncOutput {
+		if _, E := io.WriteString(out, ESC_SYNC_END); E != nil {
+			return E
+		}
+	}
+
+	return nil
+}
+
+/*
+Plays an animated GIF in place with SixelAnimate, honoring frame
+delays, disposal methods & loop count.
+
+Frames are composited onto a full-size canvas, so each redraw is
+complete.  Returns nil once the GIF's loop count is exhausted, or
+ctx.Err() when `ctx` is cancelled.
+*/
+func SixelAnimateGIF(ctx context.Context, out io.Writer, g *gif.GIF, opts SixelAnimOpts) error {
+
+	if opts.Matte == nil {
+		opts.Matte = fallbackMatte
+	}
+
+	sFrames := gifComposite(g, opts.Matte)
+	if len(sFrames) == 0 {
+		return nil
+	}
+
+	// LoopCount: 0 = FOREVER, -1 = ONCE, N = N+1 TIMES
+	nPlays := g.LoopCount + 1
+	if g.LoopCount < 0 {
+		nPlays = 1
+	}
+
+	// STOP PRODUCER WHEN PLAYBACK ENDS FOR ANY REASON
+	ctxPlay, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cFrames := make(chan SixelFrame)
+	go func() {
+		defer close(cFrames)
+		for ix_play := 0; (g.LoopCount == 0) || (ix_play < nPlays); ix_play++ {
+			for _, frm := range sFrames {
+				select {
+				case <-ctxPlay.Done():
+					return
+				case cFrames <- frm:
+				}
+			}
+		}
+	}()
+
+	return SixelAnimate(ctxPlay, out, cFrames, opts)
+}
+
+/*
+Renders each GIF frame onto a persistent canvas per its disposal
+method, then flattens the canvas onto `matte` & re-palettes it with the
+colors on it, which may come from earlier frames' local palettes.
+*/
+func gifComposite(g *gif.GIF, matte color.Color) []SixelFrame {
+
+	if matte == nil {
+		matte = color.Black
+	}
+
+	rect := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if rect.Empty() {
+		for _, pF := range g.Image {
+			rect = rect.Union(pF.Bounds())
+		}
+	}
+
+	canvas := image.NewRGBA(rect)
+	flat := image.NewRGBA(rect)
+	var prev *image.RGBA
+
+	sFrames := make([]SixelFrame, 0, len(g.Image))
+	for ix, pF := range g.Image {
+
+		disposal := byte(gif.DisposalNone)
+		if ix < len(g.Disposal) {
+			disposal = g.Disposal[ix]
+		}
+
+		if disposal == gif.DisposalPrevious {
+			prev = image.NewRGBA(rect)
+			copy(prev.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, pF.Bounds(), pF, pF.Bounds().Min, draw.Over)
+
+		// FLATTEN ONTO MATTE
+		draw.Draw(flat, rect, &image.Uniform{matte}, image.Point{}, draw.Src)
+		draw.Draw(flat, rect, canvas, rect.Min, draw.Over)
+
+		pOut := canvasPaletted(flat)
+
+		delay := 100 * time.Millisecond
+		if (ix < len(g.Delay)) && (g.Delay[ix] > 0) {
+			delay = time.Duration(g.Delay[ix]) * 10 * time.Millisecond
+		}
+
+		sFrames = append(sFrames, SixelFrame{Image: pOut, Delay: delay})
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, pF.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = prev
+		}
+	}
+
+	return sFrames
+}
+
+/*
+Opaque `flat` as a paletted image of the colors it uses: exactly, if
+there are at most 256, else the 256 most common, by nearest match.
+*/
+func canvasPaletted(flat *image.RGBA) *image.Paletted {
+
+	rect := flat.Rect
+	counts := make(map[uint32]int)
+	for ix := 0; ix < len(flat.Pix); ix += 4 {
+		p := flat.Pix[ix:]
+		counts[uint32(p[0])<<16|uint32(p[1])<<8|uint32(p[2])]++
+	}
+
+	keys := make([]uint32, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	bExact := len(keys) <= 256
+	if !bExact {
+		keys = keys[:256]
+	}
+
+	pal := make(color.Palette, len(keys))
+	ixOf := make(map[uint32]uint8, len(keys))
+	for ix, k := range keys {
+		pal[ix] = color.RGBA{uint8(k >> 16), uint8(k >> 8), uint8(k), 0xFF}
+		ixOf[k] = uint8(ix)
+	}
+
+	pOut := image.NewPaletted(rect, pal)
+	if !bExact {
+		draw.Draw(pOut, rect, flat, rect.Min, draw.Src)
+		return pOut
+	}
+
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			p := flat.Pix[y*flat.Stride+x*4:]
+			pOut.Pix[y*pOut.Stride+x] = ixOf[uint32(p[0])<<16|uint32(p[1])<<8|uint32(p[2])]
+		}
+	}
+
+	return pOut
+}
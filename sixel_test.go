@@ -0,0 +1,548 @@
+package rasterm
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 4-color test pattern: vertical stripes over a transparent top row
+func sixelTestImage(w, h int) *image.Paletted {
+
+	pal := color.Palette{
+		color.RGBA{0, 0, 0, 0},
+		color.RGBA{255, 0, 0, 255},
+		color.RGBA{0, 255, 0, 255},
+		color.RGBA{0, 0, 255, 255},
+	}
+
+	pI := image.NewPaletted(image.Rect(0, 0, w, h), pal)
+	for y := 1; y < h; y++ {
+		for x := 0; x < w; x++ {
+			pI.SetColorIndex(x, y, uint8(1+(x/4)%3))
+		}
+	}
+
+	return pI
+}
+
+func TestSixelProfiles(pT *testing.T) {
+
+	pI := sixelTestImage(200, 30)
+
+	for _, name := range []string{"xterm", "mlterm", "vt340", "generic"} {
+
+		prof, bOK := SixelProfileByName(name)
+		if !bOK {
+			pT.Fatalf("missing profile %s", name)
+		}
+
+		buf := new(bytes.Buffer)
+		if E := SixelWriteImageWithOptions(buf, pI, SixelOpts{Profile: prof}); E != nil {
+			pT.Fatal(E)
+		}
+		s := buf.String()
+
+		if !strings.HasPrefix(s, "\x1bP0;1q\"") || !strings.HasSuffix(s, "\x1b\\") {
+			pT.Errorf("%s: bad framing: %q", name, s)
+		}
+
+		if prof.LineWidth > 0 {
+			for _, ln := range strings.Split(s, "\n")[1:] {
+				if len(ln) > prof.LineWidth {
+					pT.Errorf("%s: line exceeds %d bytes: %q", name, prof.LineWidth, ln)
+				}
+			}
+		}
+
+		if prof.HLSColors != strings.Contains(s, "#1;1;") {
+			pT.Errorf("%s: unexpected color spec form", name)
+		}
+	}
+}
+
+func TestSixelVT340(pT *testing.T) {
+
+	// 20 PALETTE ENTRIES, ONLY 16 REGISTERS
+	pal := make(color.Palette, 20)
+	for ix := range pal {
+		pal[ix] = color.Gray{uint8(ix * 12)}
+	}
+	pI := image.NewPaletted(image.Rect(0, 0, 20, 12), pal)
+	for ix := range pI.Pix {
+		pI.Pix[ix] = uint8(ix % 20)
+	}
+
+	buf := new(bytes.Buffer)
+	if E := SixelWriteImageWithOptions(buf, pI, SixelOpts{Profile: SIXEL_PROFILE_VT340}); E != nil {
+		pT.Fatal(E)
+	}
+	s := buf.String()
+
+	// 2:1 PIXELS HALVE THE EMITTED HEIGHT
+	if !strings.HasPrefix(s, "\x1bP0;1q\"2;1;20;6") {
+		pT.Errorf("bad raster attributes: %q", s)
+	}
+
+	for ix := 16; ix < 20; ix++ {
+		if strings.Contains(s, "#"+strconv.Itoa(ix)) {
+			pT.Errorf("register %d used with 16-register limit", ix)
+		}
+	}
+
+	if h, l, sat := decHLS(0xffff, 0, 0); (h != 120) || (l != 50) || (sat != 100) {
+		pT.Errorf("red -> HLS %d,%d,%d", h, l, sat)
+	}
+}
+
+func TestSixelC1Controls(pT *testing.T) {
+
+	prof := SIXEL_PROFILE_XTERM
+	prof.C1Controls = true
+
+	buf := new(bytes.Buffer)
+	if E := SixelWriteImageWithOptions(buf, sixelTestImage(8, 8), SixelOpts{Profile: prof}); E != nil {
+		pT.Fatal(E)
+	}
+
+	b := buf.Bytes()
+	if (b[0] != 0x90) || (b[len(b)-1] != 0x9c) || bytes.IndexByte(b, 0x1b) >= 0 {
+		pT.Errorf("expected 8-bit DCS/ST: %q", b)
+	}
+}
+
+// Minimal DECSIXEL decoder for round-trip checks.  Returns the color
+// spec (e.g. "2;100;0;0") drawn at each pixel, "" where nothing was drawn.
+func sixelTestDecode(pT *testing.T, s string) [][]string {
+
+	ix := strings.IndexByte(s, 'q')
+	if ix < 0 {
+		pT.Fatalf("no sixel introducer: %q", s)
+	}
+	s = strings.TrimSuffix(s[ix+1:], "\x1b\\")
+
+	num := func() int {
+		n := 0
+		for (len(s) > 0) && (s[0] >= '0') && (s[0] <= '9') {
+			n = n*10 + int(s[0]-'0')
+			s = s[1:]
+		}
+		return n
+	}
+
+	var img [][]string
+	specs := map[int]string{}
+	cur, x, band := 0, 0, 0
+
+	put := func(sixl byte, ct int) {
+		for ; ct > 0; ct-- {
+			for p := 0; p < 6; p++ {
+				if (sixl-SIXEL_MIN)&(1<<uint(p)) == 0 {
+					continue
+				}
+				y := band*6 + p
+				for len(img) <= y {
+					img = append(img, nil)
+				}
+				for len(img[y]) <= x {
+					img[y] = append(img[y], "")
+				}
+				img[y][x] = specs[cur]
+			}
+			x++
+		}
+	}
+
+	for len(s) > 0 {
+
+		c := s[0]
+		s = s[1:]
+
+		switch {
+		case c == '"':
+			for (len(s) > 0) && (((s[0] >= '0') && (s[0] <= '9')) || (s[0] == ';')) {
+				s = s[1:]
+			}
+		case c == '#':
+			cur = num()
+			if (len(s) > 0) && (s[0] == ';') {
+				end := strings.IndexAny(s, "#!$-?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_`abcdefghijklmnopqrstuvwxyz{|}~")
+				specs[cur] = s[1:end]
+				s = s[end:]
+			}
+		case c == '!':
+			ct := num()
+			put(s[0], ct)
+			s = s[1:]
+		case c == '$':
+			x = 0
+		case c == '-':
+			x = 0
+			band++
+		case (c >= SIXEL_MIN) && (c <= SIXEL_MAX):
+			put(c, 1)
+		}
+	}
+
+	return img
+}
+
+func sixelSamePixels(a, b [][]string) bool {
+
+	at := func(img [][]string, x, y int) string {
+		if (y < len(img)) && (x < len(img[y])) {
+			return img[y][x]
+		}
+		return ""
+	}
+
+	h, w := len(a), 0
+	if len(b) > h {
+		h = len(b)
+	}
+	for _, rows := range [][][]string{a, b} {
+		for _, r := range rows {
+			if len(r) > w {
+				w = len(r)
+			}
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if at(a, x, y) != at(b, x, y) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func TestSixelOptimize(pT *testing.T) {
+
+	// SPARSE IMAGE: SMALL SHAPES OF UNRELATED COLORS IN A WIDE FIELD
+	pal := make(color.Palette, 64)
+	for ix := range pal {
+		pal[ix] = color.RGBA{uint8(ix * 4), uint8(255 - ix*4), uint8(ix), 255}
+	}
+	pal[0] = color.RGBA{}
+	pI := image.NewPaletted(image.Rect(0, 0, 300, 40), pal)
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 300; x++ {
+			if (x%50 < 10) && (y%7 < 4) {
+				pI.SetColorIndex(x, y, uint8(10+(x/50)))
+			}
+		}
+	}
+
+	plain, opt := new(bytes.Buffer), new(bytes.Buffer)
+	var stats SixelStats
+
+	if E := SixelWriteImage(plain, pI); E != nil {
+		pT.Fatal(E)
+	}
+	if E := SixelWriteImageWithOptions(opt, pI, SixelOpts{Optimize: true, Stats: &stats}); E != nil {
+		pT.Fatal(E)
+	}
+
+	if (stats.Bytes != int64(opt.Len())) || (stats.UnoptimizedBytes != int64(plain.Len())) {
+		pT.Errorf("stats mismatch: %+v, plain %d, opt %d", stats, plain.Len(), opt.Len())
+	}
+
+	if (stats.Saved() <= 0) || (stats.Registers != 6) {
+		pT.Errorf("expected savings w/ 6 registers: %+v", stats)
+	}
+
+	// ONE PASS PER BAND: NO GRAPHICS CR NEEDED FOR DISJOINT COLORS
+	if strings.Contains(opt.String(), "$") {
+		pT.Errorf("unexpected graphics CR: %q", opt.String())
+	}
+
+	dec := sixelTestDecode(pT, plain.String())
+	if (len(dec) != 39) || (dec[0][0] == "") {
+		pT.Fatalf("decoder sanity check failed: %d rows", len(dec))
+	}
+
+	if !sixelSamePixels(dec, sixelTestDecode(pT, opt.String())) {
+		pT.Error("optimized output draws different pixels")
+	}
+
+	// UNOPTIMIZED SIZE COUNTED IN THE SAME PASS, EXACT FOR EVERY PROFILE
+	for _, prof := range []SixelProfile{SIXEL_PROFILE_XTERM, SIXEL_PROFILE_MLTERM, SIXEL_PROFILE_VT340, SIXEL_PROFILE_GENERIC} {
+
+		so := SixelOpts{Profile: prof, AtCell: image.Pt(2, 3), Cursor: CURSOR_BELOW}
+		plain.Reset()
+		SixelWriteImageWithOptions(plain, pI, so)
+
+		so.Optimize, so.Stats = true, &stats
+		SixelWriteImageWithOptions(io.Discard, pI, so)
+		if stats.UnoptimizedBytes != int64(plain.Len()) {
+			pT.Errorf("%s: unoptimized %d, expected %d", prof.Name, stats.UnoptimizedBytes, plain.Len())
+		}
+	}
+
+	// OVERLAPPING COLORS STILL ROUND-TRIP
+	pI = sixelTestImage(97, 23)
+	plain.Reset()
+	opt.Reset()
+	SixelWriteImage(plain, pI)
+	SixelWriteImageWithOptions(opt, pI, SixelOpts{Optimize: true})
+	if !sixelSamePixels(sixelTestDecode(pT, plain.String()), sixelTestDecode(pT, opt.String())) {
+		pT.Error("optimized output draws different pixels")
+	}
+}
+
+func TestSixelSubImage(pT *testing.T) {
+
+	pI := sixelTestImage(64, 48)
+	rect := image.Rect(13, 7, 45, 40)
+
+	// REFERENCE: COPY OF THE RECTANGLE AT ORIGIN
+	pRef := image.NewPaletted(image.Rect(0, 0, rect.Dx(), rect.Dy()), pI.Palette)
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			pRef.SetColorIndex(x, y, pI.ColorIndexAt(rect.Min.X+x, rect.Min.Y+y))
+		}
+	}
+
+	ref, sub, crop := new(bytes.Buffer), new(bytes.Buffer), new(bytes.Buffer)
+	SixelWriteImage(ref, pRef)
+
+	if E := SixelWriteImage(sub, pI.SubImage(rect).(*image.Paletted)); E != nil {
+		pT.Fatal(E)
+	}
+
+	opts := SixelOpts{
+		SrcX:      rect.Min.X,
+		SrcY:      rect.Min.Y,
+		SrcWidth:  rect.Dx(),
+		SrcHeight: rect.Dy(),
+	}
+	if E := SixelWriteImageWithOptions(crop, pI, opts); E != nil {
+		pT.Fatal(E)
+	}
+
+	if (ref.String() != sub.String()) || (ref.String() != crop.String()) {
+		pT.Error("sub-image / source rectangle output differs from copied region")
+	}
+
+	// SOURCE RECTANGLE IS RELATIVE TO SUB-IMAGE ORIGIN, CLIPPED TO BOUNDS
+	crop.Reset()
+	opts = SixelOpts{SrcX: 3, SrcY: 2, SrcWidth: 1000}
+	if E := SixelWriteImageWithOptions(crop, pI.SubImage(image.Rect(10, 5, 48, 42)).(*image.Paletted), opts); E != nil {
+		pT.Fatal(E)
+	}
+	if !strings.HasPrefix(crop.String(), "\x1bP0;1q\"1;1;35;35") {
+		pT.Errorf("bad raster attributes: %q", crop.String())
+	}
+}
+
+func sixelTestGIF(nFrames int) *gif.GIF {
+
+	g := &gif.GIF{LoopCount: -1}
+	for ix := 0; ix < nFrames; ix++ {
+
+		// FRAME COVERS A MOVING HALF OF THE CANVAS
+		pF := sixelTestImage(8, 12)
+		pF.Rect = image.Rect(ix, 0, ix+8, 12)
+		g.Image = append(g.Image, pF)
+		g.Delay = append(g.Delay, 1)
+		g.Disposal = append(g.Disposal, gif.DisposalBackground)
+	}
+	g.Config = image.Config{Width: 8 + nFrames, Height: 12}
+
+	return g
+}
+
+func TestSixelAnimateGIF(pT *testing.T) {
+
+	// SYNCHRONIZED AS THE TERMINAL REPORTS; 12 PX HIGH IN 5 PX CELLS = 3 ROWS
+	buf := new(bytes.Buffer)
+	C := &Capabilities{SyncOutput: true, Geometry: TermGeometry{CellWidth: 5, CellHeight: 5}}
+	opts := SixelAnimOpts{Caps: C, Matte: color.Black}
+	if E := SixelAnimateGIF(context.Background(), buf, sixelTestGIF(3), opts); E != nil {
+		pT.Fatal(E)
+	}
+	s := buf.String()
+
+	if (strings.Count(s, ESC_CURSOR_SAVE) != 1) || (strings.Count(s, ESC_CURSOR_RESTORE) != 3) {
+		pT.Errorf("expected 1 save & 3 restores: %q", s)
+	}
+
+	if (strings.Count(s, ESC_SYNC_BEGIN) != 3) || !strings.HasSuffix(s, "\x1b\\"+ESC_SYNC_END+ESC_CURSOR_RESTORE+"\x1b[3B") {
+		pT.Errorf("expected synchronized frames, then the cursor below: %q", s)
+	}
+
+	// LAST FRAME: TRANSPARENT LEFT COLUMNS FLATTENED ONTO BLACK MATTE
+	s = strings.TrimSuffix(s, ESC_CURSOR_RESTORE+"\x1b[3B")
+	ix := strings.LastIndex(s, ESC_CURSOR_RESTORE)
+	img := sixelTestDecode(pT, s[ix:])
+	if (img[5][0] != "2;0;0;0") || (img[5][2] != "2;100;0;0") {
+		pT.Errorf("unexpected composite: %v", img[5])
+	}
+
+	// NOT REPORTED, NOT ASKED FOR: NO SYNCHRONIZED OUTPUT; AtCell PINS THE END TOO
+	buf.Reset()
+	opts = SixelAnimOpts{Caps: &Capabilities{}, Sixel: SixelOpts{AtCell: image.Pt(3, 2)}}
+	if E := SixelAnimateGIF(context.Background(), buf, sixelTestGIF(1), opts); E != nil {
+		pT.Fatal(E)
+	}
+	if s = buf.String(); strings.Contains(s, ESC_SYNC_BEGIN) || !strings.HasSuffix(s, "\x1b\\"+ESC_CURSOR_RESTORE+"\x1b[2;3H\x1b[1B") {
+		pT.Errorf("expected plain frames, then the cursor below: %q", s)
+	}
+}
+
+func TestGifComposite(pT *testing.T) {
+
+	red, green, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255}, color.RGBA{0, 0, 255, 255}
+
+	// FRAME 2'S LOCAL PALETTE LACKS FRAME 1'S RED
+	f1 := image.NewPaletted(image.Rect(0, 0, 2, 1), color.Palette{red, green})
+	f1.Pix = []uint8{0, 1}
+	f2 := image.NewPaletted(image.Rect(1, 0, 2, 1), color.Palette{blue})
+
+	// FULL 256-COLOR PALETTE, TRANSPARENT ENTRY: MATTE SHOWS THROUGH
+	pal := make(color.Palette, 256)
+	for ix := range pal {
+		pal[ix] = color.RGBA{uint8(ix), 128, 128, 255}
+	}
+	pal[0] = color.Transparent
+	f3 := image.NewPaletted(image.Rect(0, 0, 2, 1), pal)
+	f3.Pix = []uint8{0, 5}
+
+	g := &gif.GIF{
+		Image:    []*image.Paletted{f1, f2, f3},
+		Delay:    []int{1, 1, 1},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalNone, gif.DisposalBackground},
+		Config:   image.Config{Width: 2, Height: 1},
+	}
+
+	white := color.RGBA{255, 255, 255, 255}
+	sFrames := gifComposite(g, white)
+
+	type tcase struct {
+		ixFrame int
+		exp     [2]color.RGBA
+	}
+	sTests := []tcase{
+		{0, [2]color.RGBA{red, green}},
+		{1, [2]color.RGBA{red, blue}},
+
+		// FRAME 3 OVER FRAME 2: TRANSPARENT KEEPS RED
+		{2, [2]color.RGBA{red, {5, 128, 128, 255}}},
+	}
+
+	for _, t := range sTests {
+		for x := 0; x < 2; x++ {
+			if c := color.RGBAModel.Convert(sFrames[t.ixFrame].Image.At(x, 0)); c != t.exp[x] {
+				pT.Errorf("frame %d, x %d: expected %v, got %v", t.ixFrame, x, t.exp[x], c)
+			}
+		}
+	}
+
+	// NOTHING BEHIND A TRANSPARENT PIXEL: MATTE
+	g.Image, g.Delay, g.Disposal = []*image.Paletted{f3}, []int{1}, []byte{gif.DisposalNone}
+	if c := color.RGBAModel.Convert(gifComposite(g, white)[0].Image.At(0, 0)); c != white {
+		pT.Errorf("expected matte behind full palette, got %v", c)
+	}
+}
+
+func TestSixelAnimateCancel(pT *testing.T) {
+
+	g := sixelTestGIF(2)
+	g.LoopCount = 0
+
+	// CANCELLED AS THE 5TH FRAME ENDS
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	buf := new(bytes.Buffer)
+	wri := &cancelWri{Buffer: buf, nFrames: 5, cancel: cancel}
+	opts := SixelAnimOpts{Caps: &Capabilities{}}
+	if E := SixelAnimateGIF(ctx, wri, g, opts); E != context.Canceled {
+		pT.Fatalf("expected cancellation, got %v", E)
+	}
+
+	// 16 PX CELLS: CURSOR MOVED 1 ROW BELOW THE LAST FRAME
+	s := buf.String()
+	if (strings.Count(s, "\x1bP") != 5) || !strings.HasSuffix(s, "\x1b\\"+ESC_CURSOR_RESTORE+"\x1b[1B") {
+		pT.Errorf("unexpected output: %q", s)
+	}
+}
+
+// cancels once `nFrames` sixel images have been written
+type cancelWri struct {
+	*bytes.Buffer
+	nFrames int
+	cancel  context.CancelFunc
+}
+
+func (w *cancelWri) Write(p []byte) (int, error) {
+	n, E := w.Buffer.Write(p)
+	if strings.Count(w.String(), "\x1b\\") >= w.nFrames {
+		w.cancel()
+	}
+	return n, E
+}
+
+func TestSixelCursorPlacement(pT *testing.T) {
+
+	// 8 x 8 PX, BANDED TO 12, IN 4 x 5 PX CELLS: 2 x 3 CELLS
+	g := TermGeometry{CellWidth: 4, CellHeight: 5}
+
+	type tcase struct {
+		opts      SixelOpts
+		pre, post string
+	}
+
+	sTests := []tcase{
+		{SixelOpts{AtCell: image.Pt(4, 2)}, "\x1b[2;4H\x1bP", "\x1b\\"},
+		{SixelOpts{AtCell: image.Pt(4, 0)}, "\x1b[4G\x1bP", "\x1b\\"},
+		{SixelOpts{AtCell: image.Pt(-1, 3)}, "\x1b[3d\x1bP", "\x1b\\"},
+		{SixelOpts{AtCell: image.Pt(4, 2), Cursor: CURSOR_BELOW, Geometry: g}, "\x1b[2;4H" + ESC_CURSOR_SAVE + "\x1bP", "\x1b\\" + ESC_CURSOR_RESTORE + "\x1b[3B"},
+		{SixelOpts{Cursor: CURSOR_BESIDE, Geometry: g}, ESC_CURSOR_SAVE + "\x1bP", "\x1b\\" + ESC_CURSOR_RESTORE + "\x1b[2C"},
+
+		// DOUBLE-HEIGHT PIXELS: 4 SIXEL ROWS, 1 BAND = 12 PX
+		{SixelOpts{Profile: SixelProfile{PixelAspect: 2}, Cursor: CURSOR_BELOW, Geometry: g}, ESC_CURSOR_SAVE + "\x1bP", ESC_CURSOR_RESTORE + "\x1b[3B"},
+	}
+
+	buf := new(bytes.Buffer)
+	for _, t := range sTests {
+
+		buf.Reset()
+		if E := SixelWriteImageWithOptions(buf, sixelTestImage(8, 8), t.opts); E != nil {
+			pT.Fatal(E)
+		}
+
+		if s := buf.String(); !strings.HasPrefix(s, t.pre) || !strings.HasSuffix(s, t.post) {
+			pT.Errorf("%+v: unexpected cursor handling: %q", t.opts, s)
+		}
+	}
+}
+
+func TestSixelMatte(pT *testing.T) {
+
+	// HALF-TRANSPARENT RED OVER WHITE, FULLY TRANSPARENT LEFT UNDRAWN
+	pI := image.NewPaletted(image.Rect(0, 0, 2, 6), color.Palette{
+		color.NRGBA{0xFF, 0, 0, 0x80},
+		color.NRGBA{0, 0, 0, 0},
+	})
+	pI.Pix[1] = 1
+
+	buf := new(bytes.Buffer)
+	if E := SixelWriteImageWithOptions(buf, pI, SixelOpts{Matte: color.White}); E != nil {
+		pT.Fatal(E)
+	}
+
+	img := sixelTestDecode(pT, buf.String())
+	if (img[0][0] != "2;100;49;49") || ((len(img[0]) > 1) && (img[0][1] != "")) {
+		pT.Errorf("unexpected blend: %v", img[0])
+	}
+}
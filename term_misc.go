@@ -1,31 +1,56 @@
 package rasterm
 
 import (
+	"context"
 	"errors"
 	"os"
 	"regexp"
-	"strconv"
 	"strings"
-	"sync"
 	"time"
-
-	"golang.org/x/term"
 )
 
 const (
-	ESC_ERASE_DISPLAY = "\x1b[2J\x1b[0;0H"
+	ESC_ERASE_DISPLAY  = "\x1b[2J\x1b[0;0H"
+	ESC_CURSOR_SAVE    = "\x1b7"       // DECSC
+	ESC_CURSOR_RESTORE = "\x1b8"       // DECRC
+	ESC_SYNC_BEGIN     = "\x1b[?2026h" // synchronized output: hold screen updates
+	ESC_SYNC_END       = "\x1b[?2026l" // synchronized output: draw held updates
 )
 
 var (
-	E_NON_TTY   = errors.New("NON TTY")
-	E_TIMED_OUT = errors.New("TERM RESPONSE TIMED OUT")
+	E_NON_TTY      = errors.New("NON TTY")
+	E_TIMED_OUT    = errors.New("TERM RESPONSE TIMED OUT")
+	E_BAD_RESPONSE = errors.New("UNEXPECTED TERM RESPONSE")
 )
 
+// checks for tmux or GNU screen by $TERM.  See IsTmux & IsScreen to
+// tell them apart.
 func IsTmuxScreen() bool {
 	TERM := strings.ToLower(strings.TrimSpace(os.Getenv("TERM")))
 	return strings.HasPrefix(TERM, "screen")
 }
 
+var (
+	// Default time to wait for a terminal response.  Generous enough
+	// for slow ssh links; responsive terminals answer well before it.
+	TERM_QUERY_TIMEOUT = 500 * time.Millisecond
+
+	// Response terminators for TermQueryOpts
+	RX_TERM_ANY = regexp.MustCompile(`\x1b\[[\x30-\x3f]*[\x20-\x2f]*[\x40-\x7e]|\x1b[P\]_^][^\x07\x1b]*(?:\x07|\x1b\\)`) // complete CSI, DCS, OSC, APC or PM
+	RX_TERM_ST  = regexp.MustCompile(`\x07|\x1b\\`)                                                                      // end of DCS, OSC, APC or PM string
+	RX_TERM_DA1 = regexp.MustCompile(`\x1b\[\?[\d;]*c`)                                                                  // primary DA reply
+)
+
+type TermQueryOpts struct {
+	// The response is complete once the accumulated bytes match.
+	// Defaults to RX_TERM_ANY.
+	Terminator *regexp.Regexp
+
+	// Maximum time to wait for a complete response.
+	// Defaults to RASTERM_QUERY_TIMEOUT, then TERM_QUERY_TIMEOUT.
+	Timeout time.Duration
+}
+
 /*
 Handles request/response terminal control sequences like <ESC>[0c
 
@@ -34,7 +59,8 @@ os.Stdin & os.Stdout are usually sufficient.
 
 `sRq` should be the request control sequence to the terminal.
 
-NOTE: only captures up to 1KB of response
+Waits up to TERM_QUERY_TIMEOUT for one complete control sequence.
+See TermRequestResponseWithOptions.
 
 NOTE: when println debugging the response, probably want to go-escape
 it, like:
@@ -45,80 +71,20 @@ since most responses begin with <ESC>, which the terminal treats as
 another control sequence rather than text to output.
 */
 func TermRequestResponse(fileIN, fileOUT *os.File, sRq string) (sRsp []byte, E error) {
+	return TermRequestResponseWithOptions(fileIN, fileOUT, sRq, TermQueryOpts{})
+}
 
-	// 	defer func() {
-	// 		if E != nil {
-	// 			if _, file, line, ok := runtime.Caller(1); ok {
-	// 				E = fmt.Errorf("%s:%d - %s", file, line, E.Error())
-	// 			}
-	// 		}
-	// 	}()
-
-	fdIN := int(fileIN.Fd())
-
-	// NOTE: raw mode tip came from https://play.golang.org/p/kcMLTiDRZY
-	if !term.IsTerminal(fdIN) {
-		return nil, E_NON_TTY
-	}
-
-	// STDIN "RAW MODE" TO CAPTURE TERMINAL RESPONSE
-	// NOTE: without this, response bypasses stdin,
-	//       and is written directly to the console
-	var oldState *term.State
-	if oldState, E = term.MakeRaw(fdIN); E != nil {
-		return
-	}
-	defer func() {
-		// CAPTURE RESTORE ERROR (IF ANY) IF THERE HASN'T ALREADY BEEN AN ERROR
-		if e2 := term.Restore(fdIN, oldState); E == nil {
-			E = e2
-		}
-	}()
-
-	// SEND REQUEST
-	if _, E = fileOUT.Write([]byte(sRq)); E != nil {
-		return
-	}
-
-	TMP := make([]byte, 1024)
-
-	// WAIT 1/16 SECOND FOR TERM RESPONSE.  IF TIMER EXPIRES,
-	// TRIGGER BYTES TO STDIN SO .Read() CAN FINISH
-	tmr := time.NewTimer(time.Second >> 4)
-	cDone := make(chan bool)
-	WG := sync.WaitGroup{}
-	WG.Add(1)
-	go func() {
-		select {
-		case <-tmr.C:
-			// "Report Cursor Position (CPR) [row; column]
-			// JUST TO GET SOME BYTES TO STDIN
-			// NOTE: seems to work for everything except mlterm
-			fileOUT.Write([]byte("\x1b\x1b[" + "6n"))
-			break
-		case <-cDone:
-			break
-		}
-		WG.Done()
-	}()
-
-	// CAPTURE RESPONSE
-	nBytes, E := fileIN.Read(TMP)
-
-	// ENSURE GOROUTINE TERMINATION
-	if tmr.Stop() {
-		cDone <- true
-	} else {
-		// fmt.Fprintf(os.Stderr, "%#v\n", string(TMP[1:nBytes]))
-		E = E_TIMED_OUT
-	}
-	WG.Wait()
-
-	if (nBytes > 0) && (E != E_TIMED_OUT) {
-		return TMP[:nBytes], nil
-	}
+/*
+Sends `sRq`, then accumulates the terminal's response across reads
+until it matches opts.Terminator, or until opts.Timeout expires
+(E_TIMED_OUT).
 
-	return nil, E
+Reads wait on poll(2) rather than blocking, so nothing is injected into
+the terminal's input to cancel them.  See TermIO.Query for other
+readers & writers.
+*/
+func TermRequestResponseWithOptions(fileIN, fileOUT *os.File, sRq string, opts TermQueryOpts) ([]byte, error) {
+	return NewFileTermIO(fileIN, fileOUT).Query(context.Background(), sRq, opts)
 }
 
 /*
@@ -161,20 +127,19 @@ https://invisible-island.net/xterm/ctlseqs/ctlseqs.html#h4-Functions-using-CSI-_
 */
 
 func RequestTermAttributes() (sAttrs []int, E error) {
+	return StdTermIO().RequestTermAttributes(context.Background())
+}
+
+// See RequestTermAttributes.
+func (t *TermIO) RequestTermAttributes(ctx context.Context) (sAttrs []int, E error) {
 
-	text, E := TermRequestResponse(os.Stdin, os.Stdout, "\x1b[0c")
+	text, E := t.Query(ctx, "\x1b[0c", TermQueryOpts{Terminator: RX_TERM_DA1})
 	if E != nil {
 		return
 	}
 
 	// EXTRACT CODES
-	t2 := rxNumber.FindAll(text, -1)
-	sAttrs = make([]int, len(t2))
-	for ix, sN := range t2 {
-		iN, _ := strconv.Atoi(string(sN))
-		sAttrs[ix] = iN
-	}
-
+	sAttrs = parseNumbers(text)
 	return
 }
 
@@ -184,13 +149,50 @@ func lcaseEnv(k string) string {
 	return strings.ToLower(strings.TrimSpace(os.Getenv(k)))
 }
 
+var envIdentifierKeys = []string{
+	"TERM", "TERM_PROGRAM", "TERM_PROGRAM_VERSION", "LC_TERMINAL", "LC_TERMINAL_VERSION",
+	"VIM_TERMINAL", "KITTY_WINDOW_ID", "KONSOLE_VERSION", "VTE_VERSION", "WT_SESSION",
+	ENV_PROTOCOL,
+}
+
+func isEnvIdentifier(K string) bool {
+	for _, v := range envIdentifierKeys {
+		if v == K {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Returns lowercased terminal identification variables, including the
+RASTERM_PROTOCOL override.
+
+Inside tmux, these describe tmux itself, so values known to tmux for
+the attached client (see QueryTmuxClient) take precedence.  That runs
+`tmux display` to find the client, & for a new client, `tmux display` &
+`tmux show-environment` for its details.
+*/
 func GetEnvIdentifiers() map[string]string {
 
-	KEYS := []string{"TERM", "TERM_PROGRAM", "LC_TERMINAL", "VIM_TERMINAL", "KITTY_WINDOW_ID"}
 	V := make(map[string]string)
-	for _, K := range KEYS {
+	for _, K := range envIdentifierKeys {
 		V[K] = lcaseEnv(K)
 	}
 
+	if IsTmux() {
+		if C, E := cachedTmuxClient(); E == nil {
+
+			// TMUX'S OWN TERM_PROGRAM IS NOT THE CLIENT'S
+			if V["TERM_PROGRAM"] == "tmux" {
+				V["TERM_PROGRAM"], V["TERM_PROGRAM_VERSION"] = "", ""
+			}
+
+			for K, v := range C.Env {
+				V[K] = v
+			}
+		}
+	}
+
 	return V
 }
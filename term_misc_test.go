@@ -0,0 +1,36 @@
+package rasterm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseDECRPM(pT *testing.T) {
+
+	tbl := []struct {
+		rsp  string
+		mode int
+		st   DecModeState
+		bErr bool
+	}{
+		{"\x1b[?80;2$y", DECMODE_SIXEL_DISPLAY, DECRPM_RESET, false},
+		{"\x1b[?8452;1$y", DECMODE_SIXEL_CURSOR_RIGHT, DECRPM_SET, false},
+		{"\x1b[?2026;0$y", DECMODE_SYNC_OUTPUT, DECRPM_NOT_RECOGNIZED, false},
+		{"\x1b[?80;2$y", DECMODE_SYNC_OUTPUT, DECRPM_NOT_RECOGNIZED, true},
+		{"\x1b[?62;4c", DECMODE_SIXEL_DISPLAY, DECRPM_NOT_RECOGNIZED, true},
+	}
+
+	for _, t := range tbl {
+		st, E := parseDECRPM([]byte(t.rsp), t.mode)
+		if (st != t.st) || ((E != nil) != t.bErr) {
+			pT.Errorf("%q: got %v, %v", t.rsp, st, E)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	SetPrivateMode(buf, DECMODE_SIXEL_DISPLAY, true)
+	SetPrivateMode(buf, DECMODE_SIXEL_CURSOR_RIGHT, false)
+	if buf.String() != "\x1b[?80h\x1b[?8452l" {
+		pT.Errorf("unexpected DECSET/DECRST: %q", buf.String())
+	}
+}
@@ -0,0 +1,324 @@
+package rasterm
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Terminal identification, see IdentifyTerminal.
+type TermID struct {
+	Name    string // canonical lowercase name, e.g. "wezterm"
+	Version string
+	Source  string // e.g. "query:XTVERSION", "query:DA2", "env:TERM_PROGRAM"
+}
+
+/*
+NOTE: the calling program MUST be connected to an actual terminal for this to work
+
+Requests the terminal's name & version with XTVERSION:
+
+	CSI > q  ⇒  DCS > | text ST
+
+Returns the raw text, e.g. "XTerm(388)" or "WezTerm 20240203-110809-5046fc22".
+*/
+func RequestXTVersion() (string, error) {
+	return StdTermIO().RequestXTVersion(context.Background())
+}
+
+/*
+NOTE: the calling program MUST be connected to an actual terminal for this to work
+
+Requests secondary device attributes:
+
+	CSI > c  ⇒  CSI > Pp ; Pv ; Pc c
+
+where Pp is the terminal type, Pv the firmware version & Pc the ROM
+cartridge registration number (usually 0).
+*/
+func RequestSecondaryDA() ([]int, error) {
+	return StdTermIO().RequestSecondaryDA(context.Background())
+}
+
+/*
+NOTE: the calling program MUST be connected to an actual terminal for this to work
+
+Identifies the terminal from XTVERSION, then secondary DA, then
+environment identifiers.  Queries come first since environment
+variables like $TERM_PROGRAM are often missing or stale over ssh.
+*/
+func IdentifyTerminal() (TermID, error) {
+	return StdTermIO().IdentifyTerminal(context.Background())
+}
+
+// See RequestXTVersion.
+func (t *TermIO) RequestXTVersion(ctx context.Context) (string, error) {
+
+	rsp, E := t.Query(ctx, "\x1b[>q", TermQueryOpts{Terminator: rxXTVERSION})
+	if E != nil {
+		return "", E
+	}
+
+	if s, bOK := parseXTVERSION(rsp); bOK {
+		return s, nil
+	}
+
+	return "", E_BAD_RESPONSE
+}
+
+// See RequestSecondaryDA.
+func (t *TermIO) RequestSecondaryDA(ctx context.Context) ([]int, error) {
+
+	rsp, E := t.Query(ctx, "\x1b[>c", TermQueryOpts{Terminator: rxDA2})
+	if E != nil {
+		return nil, E
+	}
+
+	if v := parseDA2(rsp); v != nil {
+		return v, nil
+	}
+
+	return nil, E_BAD_RESPONSE
+}
+
+// See IdentifyTerminal.
+func (t *TermIO) IdentifyTerminal(ctx context.Context) (TermID, error) {
+
+	V := GetEnvIdentifiers()
+
+	s, E := t.RequestXTVersion(ctx)
+	if E == nil {
+		if id := termFromXTVersion(s); id.Name != "" {
+			return id, nil
+		}
+	} else if (E != E_TIMED_OUT) && (E != E_BAD_RESPONSE) {
+		return termFromEnv(V), E
+	}
+
+	if sDA2, E := t.RequestSecondaryDA(ctx); E == nil {
+		if id := termFromDA2(sDA2); id.Name != "" {
+			return id, nil
+		}
+	}
+
+	return termFromEnv(V), nil
+}
+
+var (
+	rxXTVERSION = regexp.MustCompile(`\x1bP>\|([^\x1b\x07]*)(?:\x1b\\|\x07)`)
+	rxDA2       = regexp.MustCompile(`\x1b\[>([\d;]*)c`)
+)
+
+func parseXTVERSION(rsp []byte) (string, bool) {
+
+	m := rxXTVERSION.FindSubmatch(rsp)
+	if m == nil {
+		return "", false
+	}
+
+	return string(m[1]), true
+}
+
+func parseDA2(rsp []byte) []int {
+
+	m := rxDA2.FindSubmatch(rsp)
+	if m == nil {
+		return nil
+	}
+
+	// PAD TO Pp ; Pv ; Pc
+	v := parseNumbers(m[1])
+	for len(v) < 3 {
+		v = append(v, 0)
+	}
+
+	return v
+}
+
+// XTVERSION name prefixes (lowercase) -> canonical terminal names
+var xtVersionNames = map[string]string{
+	"xterm":           "xterm",
+	"wezterm":         "wezterm",
+	"foot":            "foot",
+	"kitty":           "kitty",
+	"konsole":         "konsole",
+	"iterm2":          "iterm2",
+	"ghostty":         "ghostty",
+	"mintty":          "mintty",
+	"mlterm":          "mlterm",
+	"contour":         "contour",
+	"rio":             "rio",
+	"alacritty":       "alacritty",
+	"tmux":            "tmux",
+	"st":              "st",
+	"terminology":     "terminology",
+	"windowsterminal": "windows terminal",
+	"vte":             "vte",
+}
+
+var rxXTVersionText = regexp.MustCompile(`^\s*([A-Za-z][\w.-]*?)(?:\s*\(([^)]*)\)|\s+(\S+))?\s*$`)
+
+/*
+Maps XTVERSION text to a terminal.  Accepts both the "name(version)"
+form used by xterm, foot & kitty, and the "name version" form used by
+WezTerm, iTerm2, Konsole & tmux.  Unknown names are passed through.
+*/
+func termFromXTVersion(s string) TermID {
+
+	m := rxXTVersionText.FindStringSubmatch(s)
+	if m == nil {
+		return TermID{}
+	}
+
+	name := strings.ToLower(m[1])
+	if canon, bOK := xtVersionNames[name]; bOK {
+		name = canon
+	}
+
+	ver := m[2]
+	if ver == "" {
+		ver = m[3]
+	}
+
+	return TermID{Name: name, Version: ver, Source: "query:XTVERSION"}
+}
+
+// secondary DA signature, -1 matches any value
+type da2Entry struct {
+	Pp, Pv, Pc int
+	Name       string
+	fnVersion  func(Pv int) string
+}
+
+// MMmmpp style version numbers
+func da2VersionDotted(Pv int) string {
+	return fmt.Sprintf("%d.%d.%d", Pv/10000, (Pv/100)%100, Pv%100)
+}
+
+/*
+Secondary DA signatures, most specific first.
+
+See the README "known responses" table for sources.  Several terminals
+share generic replies (e.g. `CSI > 0 ; 95 ; 0 c` for both iTerm2 & rio),
+so those are left out -- DA2 only decides when XTVERSION is unanswered.
+Nor is foot's `CSI > 1 ; <version> ; 0 c`, which any terminal may send;
+XTVERSION identifies it.
+*/
+var da2Entries = []da2Entry{
+	{1, 4000, -1, "kitty", nil},
+	{1, 10, 0, "ghostty", nil},
+	{1, 95, 0, "apple terminal", nil},
+	{1, 115, 0, "konsole", nil},
+	{0, 136, 0, "putty", nil},
+	{0, 100, 0, "vimterm", nil},
+	{0, 0, 0, "wezterm", nil},
+	{19, -1, 0, "xterm", nil},
+	{24, -1, 0, "mlterm", nil},
+	{65, 331, 0, "rlogin", nil},
+	{65, -1, 1, "vte", da2VersionDotted},
+	{77, -1, 0, "mintty", da2VersionDotted},
+	{83, -1, 0, "screen", da2VersionDotted},
+	{84, -1, 0, "tmux", nil},
+}
+
+func termFromDA2(sDA2 []int) TermID {
+
+	if len(sDA2) < 3 {
+		return TermID{}
+	}
+
+	match := func(want, have int) bool {
+		return (want < 0) || (want == have)
+	}
+
+	for _, e := range da2Entries {
+
+		if !match(e.Pp, sDA2[0]) || !match(e.Pv, sDA2[1]) || !match(e.Pc, sDA2[2]) {
+			continue
+		}
+
+		id := TermID{Name: e.Name, Source: "query:DA2"}
+		if e.fnVersion != nil {
+			id.Version = e.fnVersion(sDA2[1])
+		} else if e.Pv < 0 {
+			id.Version = strconv.Itoa(sDA2[1])
+		}
+
+		return id
+	}
+
+	return TermID{}
+}
+
+// environment identifier -> canonical terminal names
+var envTermNames = []struct {
+	Key, Value, Name string // empty Value matches any non-empty value
+}{
+	{"TERM_PROGRAM", "apple_terminal", "apple terminal"},
+	{"TERM_PROGRAM", "iterm.app", "iterm2"},
+	{"TERM_PROGRAM", "wezterm", "wezterm"},
+	{"TERM_PROGRAM", "ghostty", "ghostty"},
+	{"TERM_PROGRAM", "rio", "rio"},
+	{"TERM_PROGRAM", "terminology", "terminology"},
+	{"TERM_PROGRAM", "vscode", "vscode"},
+	{"TERM_PROGRAM", "tmux", "tmux"},
+	{"LC_TERMINAL", "iterm2", "iterm2"},
+	{"KITTY_WINDOW_ID", "", "kitty"},
+	{"KONSOLE_VERSION", "", "konsole"},
+	{"WT_SESSION", "", "windows terminal"},
+	{"VIM_TERMINAL", "", "vimterm"},
+	{"TERM", "xterm-kitty", "kitty"},
+	{"TERM", "xterm-ghostty", "ghostty"},
+	{"TERM", "mintty", "mintty"},
+	{"TERM", "rio", "rio"},
+	{"TERM", "foot", "foot"},
+	{"TERM", "foot-extra", "foot"},
+	{"TERM", "mlterm", "mlterm"},
+	{"VTE_VERSION", "", "vte"},
+}
+
+// identifies terminal from environment identifiers (see GetEnvIdentifiers)
+func termFromEnv(V map[string]string) TermID {
+
+	for _, e := range envTermNames {
+
+		v := V[e.Key]
+		if (v == "") || ((e.Value != "") && (v != e.Value)) {
+			continue
+		}
+
+		id := TermID{Name: e.Name, Source: "env:" + e.Key}
+		switch e.Key {
+		case "TERM_PROGRAM", "LC_TERMINAL":
+			id.Version = V[e.Key+"_VERSION"]
+		case "KONSOLE_VERSION", "VTE_VERSION":
+			id.Version = v
+		}
+
+		return id
+	}
+
+	return TermID{}
+}
+
+/*
+Image protocols supported by known terminals.  Sixel support is
+still confirmed against primary DA where the terminal answers it.
+*/
+var termProtocols = map[string][]Protocol{
+	"kitty":            {PROTO_KITTY},
+	"ghostty":          {PROTO_KITTY},
+	"wezterm":          {PROTO_KITTY, PROTO_ITERM, PROTO_SIXEL},
+	"konsole":          {PROTO_KITTY, PROTO_SIXEL},
+	"iterm2":           {PROTO_ITERM, PROTO_SIXEL},
+	"mintty":           {PROTO_ITERM, PROTO_SIXEL},
+	"rio":              {PROTO_ITERM, PROTO_SIXEL},
+	"mlterm":           {PROTO_ITERM, PROTO_SIXEL},
+	"rlogin":           {PROTO_ITERM, PROTO_SIXEL},
+	"foot":             {PROTO_SIXEL},
+	"contour":          {PROTO_SIXEL},
+	"xterm":            {PROTO_SIXEL},
+	"windows terminal": {PROTO_SIXEL},
+}
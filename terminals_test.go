@@ -0,0 +1,184 @@
+package rasterm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+/*
+Recorded terminal responses, from the README "known responses" &
+"identifications" tables, with the detection results they should give.
+
+To add a terminal, or correct one from a user report: add its row to
+the README tables & here.  Empty replies go unanswered.
+*/
+type termFixture struct {
+	Name string
+	Env  map[string]string
+
+	DA1       string // CSI 0 c
+	DA2       string // CSI > c
+	XTVersion string // CSI > q
+	Kitty     string // kitty graphics query
+
+	// EXPECTED
+	bSixel    bool // IsSixelCapable
+	bKitty    bool // IsKittyCapable
+	bIterm    bool // IsItermCapable
+	TermName  string
+	Protocols []Protocol // Detect, most preferred first
+}
+
+const kittyOK = "\x1b_Gi=31;OK\x1b\\"
+
+var termFixtures = []termFixture{
+	{
+		Name: "apple terminal", Env: map[string]string{"TERM_PROGRAM": "Apple_Terminal"},
+		DA1: "\x1b[?1;2c", DA2: "\x1b[>1;95;0c",
+		TermName: "apple terminal",
+	},
+	{
+		Name: "ghostty", Env: map[string]string{"TERM": "xterm-ghostty"},
+		DA1: "\x1b[?62;22c", DA2: "\x1b[>1;10;0c", Kitty: kittyOK,
+		bKitty: true, TermName: "ghostty", Protocols: []Protocol{PROTO_KITTY},
+	},
+	{
+		Name: "guake",
+		DA1:  "\x1b[?65;1;9c", DA2: "\x1b[>65;5402;1c",
+		TermName: "vte",
+	},
+	{
+		Name: "iterm2", Env: map[string]string{"LC_TERMINAL": "iTerm2"},
+		DA1: "\x1b[?62;4c", DA2: "\x1b[>0;95;0c",
+		bSixel: true, bIterm: true, TermName: "iterm2", Protocols: []Protocol{PROTO_ITERM, PROTO_SIXEL},
+	},
+	{
+		Name: "kitty", Env: map[string]string{"TERM": "xterm-kitty"},
+		DA1: "\x1b[?62;c", DA2: "\x1b[>1;4000;19c", XTVersion: "\x1bP>|kitty(0.31.0)\x1b\\", Kitty: kittyOK,
+		bKitty: true, TermName: "kitty", Protocols: []Protocol{PROTO_KITTY},
+	},
+	{
+		Name: "rio", Env: map[string]string{"TERM": "rio"},
+		DA1: "\x1b[?62;4;6;22c", DA2: "\x1b[>0;95;0c",
+		bSixel: true, bIterm: true, TermName: "rio", Protocols: []Protocol{PROTO_ITERM, PROTO_SIXEL},
+	},
+	{
+		Name: "mintty", Env: map[string]string{"TERM": "mintty"},
+		DA1: "\x1b[?64;1;2;4;6;9;15;21;22;28;29c", DA2: "\x1b[>77;30104;0c",
+		bSixel: true, bIterm: true, TermName: "mintty", Protocols: []Protocol{PROTO_ITERM, PROTO_SIXEL},
+	},
+	{
+		Name: "mlterm",
+		DA1:  "\x1b[?63;1;2;3;4;7;29c", DA2: "\x1b[>24;279;0c",
+		bSixel: true, TermName: "mlterm", Protocols: []Protocol{PROTO_ITERM, PROTO_SIXEL},
+	},
+	{
+		Name: "putty",
+		DA1:  "\x1b[?6c", DA2: "\x1b[>0;136;0c",
+		TermName: "putty",
+	},
+	{
+		Name: "rlogin",
+		DA1:  "\x1b[?65;1;2;3;4;6;7;8;9;15;18;21;22;29;39;42;44c", DA2: "\x1b[>65;331;0c",
+		bSixel: true, TermName: "rlogin", Protocols: []Protocol{PROTO_ITERM, PROTO_SIXEL},
+	},
+	{
+		Name: "st",
+		DA1:  "\x1b[?6c",
+	},
+	{
+		Name: "terminology", Env: map[string]string{"TERM_PROGRAM": "terminology"},
+		DA1:      "\x1b[?64;1;9;15;18;21;22c",
+		TermName: "terminology",
+	},
+	{
+		Name: "vimterm", Env: map[string]string{"VIM_TERMINAL": "1"},
+		DA1: "\x1b[?1;2c", DA2: "\x1b[>0;100;0c",
+		TermName: "vimterm",
+	},
+	{
+		Name: "wez", Env: map[string]string{"TERM_PROGRAM": "wezterm"},
+		DA1: "\x1b[?65;4;6;18;22c", DA2: "\x1b[>0;0;0c", XTVersion: "\x1bP>|WezTerm 20240203-110809-5046fc22\x1b\\", Kitty: kittyOK,
+		bSixel: true, bKitty: true, bIterm: true, TermName: "wezterm", Protocols: []Protocol{PROTO_KITTY, PROTO_ITERM, PROTO_SIXEL},
+	},
+	{
+		Name: "xfce",
+		DA1:  "\x1b[?65;1;9c", DA2: "\x1b[>65;5402;1c",
+		TermName: "vte",
+	},
+	{
+		Name: "xterm",
+		DA1:  "\x1b[?63;1;2;4;6;9;15;22c", DA2: "\x1b[>19;344;0c", XTVersion: "\x1bP>|XTerm(388)\x1b\\",
+		bSixel: true, TermName: "xterm", Protocols: []Protocol{PROTO_SIXEL},
+	},
+}
+
+// fake terminal answering as `f` does
+func (f termFixture) TermIO(pT *testing.T) *TermIO {
+
+	rsp := map[string]string{}
+	for rq, v := range map[string]string{
+		"\x1b[0c":           f.DA1,
+		"\x1b[>c":           f.DA2,
+		"\x1b[>q":           f.XTVersion,
+		PROBE_KITTY.Request: f.Kitty,
+	} {
+		if v != "" {
+			rsp[rq] = v
+		}
+	}
+
+	in, out := fakeTerminal(pT, rsp, false)
+	tio := NewTermIO(in, out, nil)
+	tio.Timeout = 100 * time.Millisecond
+
+	return tio
+}
+
+func TestTermFixtures(pT *testing.T) {
+
+	for _, f := range termFixtures {
+		pT.Run(f.Name, func(pT *testing.T) {
+
+			clearTermEnv(pT)
+			for K, v := range f.Env {
+				pT.Setenv(K, v)
+			}
+
+			tio := f.TermIO(pT)
+			ctx := context.Background()
+
+			sAttrs, E := tio.RequestTermAttributes(ctx)
+			if (E != nil) || (fmt.Sprint(sAttrs) != fmt.Sprint(parseNumbers([]byte(f.DA1)))) {
+				pT.Errorf("RequestTermAttributes: %v, %v", sAttrs, E)
+			}
+
+			if bSix, E := tio.IsSixelCapable(ctx); (E != nil) || (bSix != f.bSixel) {
+				pT.Errorf("IsSixelCapable: %v, %v", bSix, E)
+			}
+
+			if b := IsKittyCapable(); b != f.bKitty {
+				pT.Errorf("IsKittyCapable: %v", b)
+			}
+
+			if b := IsItermCapable(); b != f.bIterm {
+				pT.Errorf("IsItermCapable: %v", b)
+			}
+
+			C, E := Detect(ctx, DetectOpts{IO: tio})
+			if E != nil {
+				pT.Fatal(E)
+			}
+
+			if C.TermName != f.TermName {
+				pT.Errorf("Detect: terminal %q (%s)", C.TermName, C.Source["term"])
+			}
+
+			if fmt.Sprint(C.Protocols) != fmt.Sprint(f.Protocols) {
+				pT.Errorf("Detect: protocols %v, want %v (%v)", C.Protocols, f.Protocols, C.Source)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package rasterm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Puts a terminal into raw mode for the duration of a query.
+type RawModer interface {
+	// Returns a function restoring the previous mode.
+	MakeRaw() (restore func() error, E error)
+}
+
+/*
+Terminal connection for request/response queries.
+
+Requests are written to `Out`, responses read from `In`.  `Raw` is
+optional: leave it nil when `In` already delivers raw bytes, e.g. a pty
+master in tests or an ssh session channel.
+
+Cancelling a read depends on what `In` is:
+
+  - a TTY *os.File (NewFileTermIO): polled, nothing is left behind.
+  - anything with SetReadDeadline (net.Conn, ...): deadlines.
+  - any other io.Reader: the read keeps running in the background after
+    a timeout, and its bytes are delivered to the next query.
+  - an InputDemux (InputDemux.TermIO): only replies are read, user input
+    is left for the application.
+*/
+type TermIO struct {
+	In  io.Reader
+	Out io.Writer
+	Raw RawModer
+
+	// Default for TermQueryOpts.Timeout.  0 means RASTERM_QUERY_TIMEOUT,
+	// then TERM_QUERY_TIMEOUT.
+	Timeout time.Duration
+
+	fileIN  *os.File
+	fileOUT *os.File
+	pending chan termReadResult
+	demux   *InputDemux
+}
+
+type termReadResult struct {
+	buf []byte
+	E   error
+}
+
+func NewTermIO(in io.Reader, out io.Writer, raw RawModer) *TermIO {
+	return &TermIO{In: in, Out: out, Raw: raw}
+}
+
+// TermIO for a terminal device, in raw mode during queries.
+func NewFileTermIO(fileIN, fileOUT *os.File) *TermIO {
+	return &TermIO{
+		In:      fileIN,
+		Out:     fileOUT,
+		Raw:     fileRawModer{int(fileIN.Fd())},
+		fileIN:  fileIN,
+		fileOUT: fileOUT,
+	}
+}
+
+// TermIO per TTY_MODE: os.Stdin & os.Stdout, or the controlling
+// terminal if they're redirected.  See OpenTermIO.
+func StdTermIO() *TermIO {
+
+	t, E := OpenTermIO(TTY_MODE)
+	if E != nil {
+		// TTY_FORCE W/O A TERMINAL: QUERIES FAIL W/ E_NON_TTY
+		return NewFileTermIO(os.Stdin, os.Stdout)
+	}
+
+	return t
+}
+
+type fileRawModer struct {
+	fd int
+}
+
+func (r fileRawModer) MakeRaw() (func() error, error) {
+
+	// NOTE: raw mode tip came from https://play.golang.org/p/kcMLTiDRZY
+	oldState, E := term.MakeRaw(r.fd)
+	if E != nil {
+		return nil, E
+	}
+
+	return func() error { return term.Restore(r.fd, oldState) }, nil
+}
+
+// poll / deadline granularity for noticing context cancellation
+const termReadSlice = 50 * time.Millisecond
+
+/*
+Sends `sRq`, then accumulates the terminal's response across reads
+until it matches opts.Terminator, opts.Timeout expires (E_TIMED_OUT),
+or `ctx` ends (ctx.Err()).
+*/
+func (t *TermIO) Query(ctx context.Context, sRq string, opts TermQueryOpts) (sRsp []byte, E error) {
+
+	if opts.Terminator == nil {
+		opts.Terminator = RX_TERM_ANY
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = t.Timeout
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultQueryTimeout()
+	}
+
+	if (t.fileIN != nil) && !term.IsTerminal(int(t.fileIN.Fd())) {
+		return nil, E_NON_TTY
+	}
+
+	// "RAW MODE" TO CAPTURE TERMINAL RESPONSE
+	// NOTE: without this, response bypasses stdin,
+	//       and is written directly to the console
+	if t.Raw != nil {
+		var restore func() error
+		if restore, E = t.Raw.MakeRaw(); E != nil {
+			return
+		}
+		defer func() {
+			// CAPTURE RESTORE ERROR (IF ANY) IF THERE HASN'T ALREADY BEEN AN ERROR
+			if e2 := restore(); E == nil {
+				E = e2
+			}
+		}()
+	}
+
+	if t.demux != nil {
+		t.demux.begin()
+		defer t.demux.end()
+	}
+
+	// SEND REQUEST
+	if _, E = io.WriteString(t.Out, sRq); E != nil {
+		return
+	}
+
+	deadline := time.Now().Add(opts.Timeout)
+	if dl, bOK := ctx.Deadline(); bOK && dl.Before(deadline) {
+		deadline = dl
+	}
+
+	// CAPTURE RESPONSE, POSSIBLY SPLIT ACROSS READS
+	TMP := make([]byte, 256)
+	for {
+
+		if E = ctx.Err(); E != nil {
+			return nil, E
+		}
+
+		remain := time.Until(deadline)
+		if remain <= 0 {
+			if E = ctx.Err(); E != nil {
+				return nil, E
+			}
+			return nil, E_TIMED_OUT
+		}
+
+		var nBytes int
+		if nBytes, E = t.readSome(ctx, TMP, remain); E != nil {
+			return nil, E
+		}
+
+		sRsp = append(sRsp, TMP[:nBytes]...)
+		if opts.Terminator.Match(sRsp) {
+			return sRsp, nil
+		}
+	}
+}
+
+type deadlineReader interface {
+	SetReadDeadline(time.Time) error
+}
+
+// reads what arrives within `remain` (or less); (0, nil) if nothing did
+func (t *TermIO) readSome(ctx context.Context, buf []byte, remain time.Duration) (int, error) {
+
+	slice := remain
+	if slice > termReadSlice {
+		slice = termReadSlice
+	}
+
+	// REPLIES SPLIT FROM APPLICATION INPUT
+	if t.demux != nil {
+		return t.demux.readReply(ctx, buf, slice)
+	}
+
+	// TERMINAL DEVICE; WITHOUT POLL, READ IN BACKGROUND BELOW
+	if (t.fileIN != nil) && termCanPoll {
+
+		n, E := termReadTimeout(t.fileIN, buf, slice)
+		if E == E_TIMED_OUT {
+			return 0, nil
+		}
+		return n, E
+	}
+
+	// NETWORK CONNECTION, ETC.
+	if dr, bOK := t.In.(deadlineReader); bOK && (dr.SetReadDeadline(time.Now().Add(slice)) == nil) {
+
+		defer dr.SetReadDeadline(time.Time{})
+
+		n, E := t.In.Read(buf)
+		if errors.Is(E, os.ErrDeadlineExceeded) {
+			return n, nil
+		}
+		if ne, bOK := E.(interface{ Timeout() bool }); bOK && ne.Timeout() {
+			return n, nil
+		}
+		return n, E
+	}
+
+	// PLAIN io.Reader: READ IN BACKGROUND, LEAVE PENDING ON TIMEOUT
+	if t.pending == nil {
+		t.pending = make(chan termReadResult, 1)
+		go func(c chan termReadResult, n int) {
+			tmp := make([]byte, n)
+			n, E := t.In.Read(tmp)
+			c <- termReadResult{tmp[:n], E}
+		}(t.pending, len(buf))
+	}
+
+	tmr := time.NewTimer(remain)
+	defer tmr.Stop()
+
+	select {
+	case r := <-t.pending:
+		t.pending = nil
+		return copy(buf, r.buf), r.E
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-tmr.C:
+		return 0, nil
+	}
+}
@@ -0,0 +1,199 @@
+package rasterm
+
+import (
+	"context"
+	"image/color"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+/*
+Fake terminal: answers each control sequence written to it with the
+matching entry of `rsp`, and ignores the rest.  Returns the application
+side reader & writer.
+*/
+func fakeTerminal(pT *testing.T, rsp map[string]string, bNetConn bool) (io.Reader, io.Writer) {
+
+	var appIn, termIn io.ReadWriteCloser
+	var appOut io.Writer
+	var termOut io.Reader
+
+	if bNetConn {
+		a, b := net.Pipe()
+		appIn, appOut, termIn, termOut = a, a, b, b
+	} else {
+		rIn, wIn := io.Pipe()
+		rOut, wOut := io.Pipe()
+		appIn, appOut = struct {
+			io.Reader
+			io.WriteCloser
+		}{rIn, wOut}, wOut
+		termIn, termOut = struct {
+			io.Reader
+			io.WriteCloser
+		}{rOut, wIn}, rOut
+	}
+
+	pT.Cleanup(func() {
+		appIn.Close()
+		termIn.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 4096)
+		var acc []byte
+		for {
+			n, E := termOut.Read(buf)
+			if E != nil {
+				return
+			}
+			acc = append(acc, buf[:n]...)
+			for {
+				loc := RX_TERM_ANY.FindIndex(acc)
+				if loc == nil {
+					break
+				}
+				if s, bOK := rsp[string(acc[loc[0]:loc[1]])]; bOK {
+					if _, E = termIn.Write([]byte(s)); E != nil {
+						return
+					}
+				}
+				acc = acc[loc[1]:]
+			}
+		}
+	}()
+
+	return appIn, appOut
+}
+
+func TestTermIOQuery(pT *testing.T) {
+
+	for _, bNet := range []bool{false, true} {
+
+		in, out := fakeTerminal(pT, map[string]string{
+			"\x1b[0c":  "\x1b[?62;4;22c",
+			"\x1b[>c":  "\x1b[>0;0;0c",
+			"\x1b[16t": "\x1b[6;20;10t",
+		}, bNet)
+
+		tio := NewTermIO(in, out, nil)
+		tio.Timeout = 100 * time.Millisecond
+		ctx := context.Background()
+
+		if bSix, E := tio.IsSixelCapable(ctx); !bSix || (E != nil) {
+			pT.Errorf("net %v: sixel %v, %v", bNet, bSix, E)
+		}
+
+		// UNANSWERED QUERY TIMES OUT, NEXT ONE STILL WORKS
+		if _, E := tio.RequestXTVersion(ctx); E != E_TIMED_OUT {
+			pT.Errorf("net %v: expected timeout, got %v", bNet, E)
+		}
+
+		if id, E := tio.IdentifyTerminal(ctx); (E != nil) || (id.Name != "wezterm") {
+			pT.Errorf("net %v: identified %+v, %v", bNet, id, E)
+		}
+
+		if g, _ := tio.QueryGeometry(ctx); (g.CellWidth != 10) || (g.CellHeight != 20) {
+			pT.Errorf("net %v: geometry %+v", bNet, g)
+		}
+
+		// CANCELLATION BEATS TIMEOUT
+		ctxC, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		t0 := time.Now()
+		_, E := tio.Query(ctxC, "\x1b[>q", TermQueryOpts{Terminator: RX_TERM_ST, Timeout: 5 * time.Second})
+		cancel()
+		if (E == nil) || (time.Since(t0) > time.Second) {
+			pT.Errorf("net %v: expected cancellation, got %v after %v", bNet, E, time.Since(t0))
+		}
+	}
+}
+
+func TestProbe(pT *testing.T) {
+
+	in, out := fakeTerminal(pT, map[string]string{
+		PROBE_XTVERSION.Request:   "\x1bP>|WezTerm 20240203-110809-5046fc22\x1b\\",
+		PROBE_KITTY.Request:       "\x1b_Gi=31;OK\x1b\\",
+		PROBE_CELL_PIXELS.Request: "\x1b[6;20;10t",
+		PROBE_SYNC_OUTPUT.Request: "\x1b[?2026;2$y",
+		PROBE_BACKGROUND.Request:  "\x1b]11;rgb:fdfd/f6f6/e3e3\x1b\\",
+		"\x1b]4;1;?\x1b\\":        "\x1b]4;1;rgb:cdcd/0000/0000\x07",
+		"\x1b[0c":                 "\x1b[?65;4;6;18;22c",
+	}, false)
+
+	// SLOW TIMEOUT: UNANSWERED QUERIES MUST NOT WAIT FOR IT
+	tio := NewTermIO(in, out, nil)
+	tio.Timeout = 5 * time.Second
+
+	t0 := time.Now()
+	R, E := tio.Probe(context.Background(), ProbeOpts{Queries: PROBE_ALL})
+	if E != nil {
+		pT.Fatal(E)
+	}
+	if time.Since(t0) > time.Second {
+		pT.Errorf("probe took %v", time.Since(t0))
+	}
+
+	for _, q := range PROBE_ALL {
+		switch q.Name {
+		case "xtversion", "kitty", "cell_pixels", "sync_output", "background":
+			if !R.Has(q.Name) {
+				pT.Errorf("missing reply for %s", q.Name)
+			}
+		default:
+			if R.Has(q.Name) {
+				pT.Errorf("unexpected reply for %s: %q", q.Name, R.Replies[q.Name])
+			}
+		}
+	}
+
+	if (len(R.DA1) != 5) || !isSixelDA1(R.DA1) {
+		pT.Errorf("unexpected DA1: %v", R.DA1)
+	}
+
+	clearTermEnv(pT)
+	C, E := Detect(context.Background(), DetectOpts{IO: tio})
+	if E != nil {
+		pT.Fatal(E)
+	}
+
+	if (C.TermName != "wezterm") || (C.Source["kitty"] != "query:kitty") || (C.Source["sixel"] != "query:DA1") {
+		pT.Errorf("unexpected capabilities: %+v", C)
+	}
+
+	if !C.SyncOutput || (C.Geometry.CellWidth != 10) || (C.Recommended != PROTO_KITTY) {
+		pT.Errorf("unexpected capabilities: %+v", C)
+	}
+
+	if (C.Background == nil) || IsDarkColor(C.Background) || (C.Foreground != nil) {
+		pT.Errorf("unexpected colors: %v, %v", C.Foreground, C.Background)
+	}
+
+	if c, E := tio.QueryPaletteColor(context.Background(), 1); (E != nil) || (c != color.RGBA64{0xcdcd, 0, 0, 0xFFFF}) {
+		pT.Errorf("palette 1: %v, %v", c, E)
+	}
+
+	if _, E := tio.QueryForeground(context.Background()); E != E_BAD_RESPONSE {
+		pT.Errorf("expected unanswered foreground, got %v", E)
+	}
+}
+
+func TestOpenTermIO(pT *testing.T) {
+
+	t, E := OpenTermIO(TTY_NEVER)
+	if (E != nil) || (t.fileIN != os.Stdin) || (t.fileOUT != os.Stdout) {
+		pT.Fatalf("TTY_NEVER: got %v, %v", t, E)
+	}
+
+	// TTY_FORCE EITHER OPENS THE CONTROLLING TERMINAL OR SAYS WHY NOT
+	t, E = OpenTermIO(TTY_FORCE)
+	if E == nil {
+		if (t.fileIN == os.Stdin) || !isTerminalFile(t.fileOUT) {
+			pT.Fatalf("TTY_FORCE: not the controlling terminal: %v", t)
+		}
+	} else if t != nil {
+		pT.Fatalf("TTY_FORCE: TermIO with error %v", E)
+	}
+}
@@ -0,0 +1,74 @@
+package rasterm
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// opens a pseudo-terminal pair: `ptm` plays the terminal, `pts` the application side
+func openPTY(pT *testing.T) (ptm, pts *os.File) {
+
+	ptm, E := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if E != nil {
+		pT.Skip("no pty:", E)
+	}
+
+	fd := int(ptm.Fd())
+	if E = unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); E != nil {
+		ptm.Close()
+		pT.Skip("no pty:", E)
+	}
+
+	n, E := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if E != nil {
+		ptm.Close()
+		pT.Skip("no pty:", E)
+	}
+
+	if pts, E = os.OpenFile("/dev/pts/"+strconv.Itoa(n), os.O_RDWR|unix.O_NOCTTY, 0); E != nil {
+		ptm.Close()
+		pT.Skip("no pty:", E)
+	}
+
+	pT.Cleanup(func() {
+		pts.Close()
+		ptm.Close()
+	})
+
+	return ptm, pts
+}
+
+func TestTermRequestResponsePTY(pT *testing.T) {
+
+	ptm, pts := openPTY(pT)
+
+	// SLOW TERMINAL: REPLY SPLIT ACROSS WRITES, SLOWER THAN 1/16 SECOND
+	go func() {
+		rq := make([]byte, 16)
+		ptm.Read(rq)
+		ptm.Write([]byte("\x1b[?62;"))
+		time.Sleep(100 * time.Millisecond)
+		ptm.Write([]byte("4;22c"))
+	}()
+
+	rsp, E := TermRequestResponseWithOptions(pts, pts, "\x1b[0c", TermQueryOpts{Terminator: RX_TERM_DA1, Timeout: time.Second})
+	if (E != nil) || (string(rsp) != "\x1b[?62;4;22c") {
+		pT.Fatalf("got %q, %v", rsp, E)
+	}
+
+	// SILENT TERMINAL: TIME OUT WITHOUT INJECTING INPUT
+	go func() {
+		rq := make([]byte, 16)
+		ptm.Read(rq)
+	}()
+
+	t0 := time.Now()
+	_, E = TermRequestResponseWithOptions(pts, pts, "\x1b[>q", TermQueryOpts{Terminator: RX_TERM_ST, Timeout: 100 * time.Millisecond})
+	if (E != E_TIMED_OUT) || (time.Since(t0) > time.Second) {
+		pT.Fatalf("expected timeout, got %v after %v", E, time.Since(t0))
+	}
+}
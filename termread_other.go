@@ -0,0 +1,45 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package rasterm
+
+import (
+	"os"
+	"runtime"
+	"time"
+)
+
+// controlling terminal: console input & output buffers on Windows
+func openTTY() (*os.File, *os.File, error) {
+
+	if runtime.GOOS != "windows" {
+		f, E := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		return f, f, E
+	}
+
+	fileIN, E := os.OpenFile("CONIN$", os.O_RDWR, 0)
+	if E != nil {
+		return nil, nil, E
+	}
+
+	fileOUT, E := os.OpenFile("CONOUT$", os.O_RDWR, 0)
+	if E != nil {
+		fileIN.Close()
+		return nil, nil, E
+	}
+
+	return fileIN, fileOUT, nil
+}
+
+/*
+termReadTimeout can't wait without reading, & a blocked read can't be
+cancelled, so TermIO reads in the background instead: on timeout the
+read stays pending for the next query, & nothing is written to the
+terminal to end it.
+*/
+const termCanPoll = false
+
+// unused without poll(2); see termCanPoll
+func termReadTimeout(_ *os.File, _ []byte, _ time.Duration) (int, error) {
+	return 0, E_TIMED_OUT
+}
@@ -0,0 +1,53 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package rasterm
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// controlling terminal, for both reading & writing
+func openTTY() (*os.File, *os.File, error) {
+
+	f, E := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if E != nil {
+		return nil, nil, E
+	}
+
+	return f, f, nil
+}
+
+// termReadTimeout can wait without reading
+const termCanPoll = true
+
+// reads whatever is available within `timeout`, E_TIMED_OUT if nothing arrives
+func termReadTimeout(fileIN *os.File, buf []byte, timeout time.Duration) (int, error) {
+
+	deadline := time.Now().Add(timeout)
+	sFd := []unix.PollFd{{Fd: int32(fileIN.Fd()), Events: unix.POLLIN}}
+
+	for {
+
+		ms := int((time.Until(deadline) + time.Millisecond - 1) / time.Millisecond)
+		if ms <= 0 {
+			return 0, E_TIMED_OUT
+		}
+
+		n, E := unix.Poll(sFd, ms)
+		if E == unix.EINTR {
+			continue
+		}
+		if E != nil {
+			return 0, E
+		}
+		if n == 0 {
+			return 0, E_TIMED_OUT
+		}
+
+		return fileIN.Read(buf)
+	}
+}
@@ -0,0 +1,202 @@
+package rasterm
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// How TextLines draws.
+type TextMode int
+
+const (
+	TEXT_BRAILLE TextMode = iota // 2x4 dots per cell, U+2800-U+28FF
+	TEXT_ASCII                   // one character per cell from a density ramp
+)
+
+// density ramp for TEXT_ASCII, sparse to dense
+const DEFAULT_ASCII_RAMP = " .:-=+*#%@"
+
+type TextOpts struct {
+	// Output size in cells, as WriteOpts: zero Cols / Rows follow from the
+	// other.  Both zero: natural size by Geometry's cell size, narrowed
+	// to Geometry.Cols when known.
+	Cols int
+	Rows int
+	Fit  FitMode
+
+	// Cell shape, from the terminal where known.  Defaults to a typical
+	// one, which also suits plain text files.
+	Geometry TermGeometry
+
+	Resampler Resampler
+
+	Mode TextMode
+
+	// Brightness (0-1) at which a braille dot is raised: 0 raises every
+	// dot, above 1 none.  Defaults to 0.5 when nil.
+	Threshold *float64
+
+	// Floyd-Steinberg error diffusion, for smoother gradients.
+	Dither bool
+
+	// Dots & dense characters for dark pixels instead of light ones, for
+	// dark text on a light background.
+	Invert bool
+
+	// Characters for TEXT_ASCII, sparse to dense.  Defaults to
+	// DEFAULT_ASCII_RAMP.
+	Ramp string
+
+	// Color transparent pixels are flattened onto.  Defaults to black,
+	// so text output never queries the terminal.
+	Matte color.Color
+}
+
+/*
+Draws an image in monochrome text, as braille dots or ASCII characters,
+one string per row of cells.  For terminals without color, or to embed
+in logs & plain text reports.
+*/
+func TextLines(iImg image.Image, opts TextOpts) []string {
+
+	if iImg.Bounds().Empty() {
+		return nil
+	}
+
+	// SAME SIZING AS BlocksEncoder
+	wo := WriteOpts{
+		Cols:      opts.Cols,
+		Rows:      opts.Rows,
+		Fit:       opts.Fit,
+		Resampler: opts.Resampler,
+		Caps:      &Capabilities{Geometry: opts.Geometry},
+	}
+	if so, bOK := wo.textScaleOpts(); bOK {
+		iImg = Scale(iImg, so)
+	}
+	cols, rows := textCells(iImg.Bounds(), opts.Geometry)
+
+	gx, gy := 1, 1
+	if opts.Mode == TEXT_BRAILLE {
+		gx, gy = 2, 4
+	}
+
+	matte := opts.Matte
+	if matte == nil {
+		matte = color.Black
+	}
+
+	iImg = Scale(iImg, ScaleOpts{Width: cols * gx, Height: rows * gy, Fit: FIT_FILL, Resampler: opts.Resampler})
+	iImg = flattenAlpha(iImg, matte)
+
+	// QUANTIZE BRIGHTNESS: 2 LEVELS FOR DOTS, 1 PER RAMP CHARACTER
+	ramp := []rune(opts.Ramp)
+	if len(ramp) == 0 {
+		ramp = []rune(DEFAULT_ASCII_RAMP)
+	}
+
+	levels := len(ramp)
+	quantize := func(v float64) float64 {
+		v = math.Max(0, math.Min(1, v))
+		return math.Round(v*float64(levels-1)) / float64(levels-1)
+	}
+
+	if opts.Mode == TEXT_BRAILLE {
+		thr := 0.5
+		if opts.Threshold != nil {
+			thr = *opts.Threshold
+		}
+		quantize = func(v float64) float64 {
+			if v >= thr {
+				return 1
+			}
+			return 0
+		}
+	} else if levels < 2 {
+		quantize = func(float64) float64 { return 0 }
+	}
+
+	lum := textLevels(iImg, opts.Invert, opts.Dither, quantize)
+	w := cols * gx
+
+	sLine := make([]string, rows)
+	var sb strings.Builder
+	for y := 0; y < rows; y++ {
+
+		sb.Reset()
+		for x := 0; x < cols; x++ {
+
+			if opts.Mode == TEXT_ASCII {
+				ix := int(math.Round(lum[y*w+x] * float64(levels-1)))
+				sb.WriteRune(ramp[ix])
+				continue
+			}
+
+			var dots rune
+			for d, bit := range brailleDots {
+				if lum[(y*4+d/2)*w+x*2+d%2] > 0 {
+					dots |= bit
+				}
+			}
+			sb.WriteRune(0x2800 + dots)
+		}
+
+		sLine[y] = sb.String()
+	}
+
+	return sLine
+}
+
+// braille dot bits by position, row by row from the top left
+var brailleDots = [8]rune{0x01, 0x08, 0x02, 0x10, 0x04, 0x20, 0x40, 0x80}
+
+// quantized brightness (0-1) per pixel, in row order
+func textLevels(iImg image.Image, bInvert, bDither bool, quantize func(float64) float64) []float64 {
+
+	b := iImg.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	lum := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, bl, _ := iImg.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			v := (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(bl)) / 0xFFFF
+			if bInvert {
+				v = 1 - v
+			}
+			lum[y*w+x] = v
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+
+			ix := y*w + x
+			q := quantize(lum[ix])
+			e := lum[ix] - q
+			lum[ix] = q
+
+			if !bDither {
+				continue
+			}
+
+			// FLOYD-STEINBERG
+			if x+1 < w {
+				lum[ix+1] += e * 7 / 16
+			}
+			if y+1 < h {
+				if x > 0 {
+					lum[ix+w-1] += e * 3 / 16
+				}
+				lum[ix+w] += e * 5 / 16
+				if x+1 < w {
+					lum[ix+w+1] += e * 1 / 16
+				}
+			}
+		}
+	}
+
+	return lum
+}
@@ -0,0 +1,96 @@
+package rasterm
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestTextLines(pT *testing.T) {
+
+	white := image.NewUniform(color.White)
+	box := func(w, h int) image.Image {
+		return sizedImage(white, image.Rect(0, 0, w, h))
+	}
+
+	// LEFT HALF WHITE, RIGHT HALF BLACK
+	half := image.NewGray(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		half.SetGray(0, y, color.Gray{255})
+		half.SetGray(1, y, color.Gray{255})
+	}
+
+	// 8x16 CELLS: 16 x 32 PX = 2 x 2 CELLS
+	type tcase struct {
+		img  image.Image
+		opts TextOpts
+		exp  []string
+	}
+
+	sTests := []tcase{
+		{box(4, 8), TextOpts{Cols: 2}, []string{"⣿⣿", "⣿⣿"}},
+		{box(4, 8), TextOpts{Cols: 2, Invert: true}, []string{"⠀⠀", "⠀⠀"}},
+		{half, TextOpts{Cols: 1, Rows: 1, Fit: FIT_FILL, Resampler: RESAMPLE_NEAREST}, []string{"⡇"}},
+		{half, TextOpts{Cols: 2, Rows: 1, Fit: FIT_FILL, Resampler: RESAMPLE_NEAREST, Mode: TEXT_ASCII}, []string{"@ "}},
+		{half, TextOpts{Cols: 2, Rows: 1, Fit: FIT_FILL, Resampler: RESAMPLE_NEAREST, Mode: TEXT_ASCII, Invert: true, Ramp: ".#"}, []string{".#"}},
+
+		// NARROWED TO THE TERMINAL: 80 x 20 PX = 10 x 1 CELLS
+		{box(400, 100), TextOpts{Geometry: TermGeometry{Cols: 10}}, []string{"⣿⣿⣿⣿⣿⣿⣿⣿⣿⣿"}},
+	}
+
+	for _, t := range sTests {
+		if s := TextLines(t.img, t.opts); strings.Join(s, "\n") != strings.Join(t.exp, "\n") {
+			pT.Errorf("%v %+v:\nexpected %q\n     got %q", t.img.Bounds().Size(), t.opts, t.exp, s)
+		}
+	}
+
+	// 50% GRAY: ABOUT HALF THE DOTS, DITHERED; NONE BY THRESHOLD
+	gray := sizedImage(image.NewUniform(color.Gray{128}), image.Rect(0, 0, 64, 64))
+	count := func(opts TextOpts) int {
+		n := 0
+		for _, s := range TextLines(gray, opts) {
+			for _, r := range s {
+				for bits := r - 0x2800; bits != 0; bits &= bits - 1 {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	thr := 0.6
+	opts := TextOpts{Cols: 8, Rows: 4, Fit: FIT_FILL, Threshold: &thr}
+	if n := count(opts); n != 0 {
+		pT.Errorf("threshold: expected no dots, got %d", n)
+	}
+
+	// ZERO RAISES ALL, UNSET IS 0.5
+	thr = 0
+	if n := count(opts); n != 256 {
+		pT.Errorf("zero threshold: expected all 256 dots, got %d", n)
+	}
+	opts.Threshold = nil
+	if n := count(opts); n != 256 {
+		pT.Errorf("default threshold: expected all 256 dots, got %d", n)
+	}
+
+	opts.Threshold = &thr
+	thr = 0.6
+	opts.Dither = true
+	if n := count(opts); (n < 90) || (n > 166) {
+		pT.Errorf("dithered: expected about 128 of 256 dots, got %d", n)
+	}
+}
+
+// `iImg` with bounds `b`
+func sizedImage(iImg image.Image, b image.Rectangle) image.Image {
+	return boundedImage{iImg, b}
+}
+
+type boundedImage struct {
+	image.Image
+	b image.Rectangle
+}
+
+func (i boundedImage) Bounds() image.Rectangle { return i.b }
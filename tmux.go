@@ -0,0 +1,134 @@
+package rasterm
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+/*
+The terminal attached to the current tmux client, as seen by tmux.
+
+Inside tmux, $TERM_PROGRAM, $LC_TERMINAL & DA replies describe tmux
+rather than the outer terminal, so they are taken from tmux instead.
+*/
+type TmuxClient struct {
+	TermName string // #{client_termname}, e.g. "xterm-kitty"
+	TermType string // #{client_termtype}, tmux 3.3+ XTVERSION-style, e.g. "kitty(0.31.0)"
+
+	// Client-side environment identifiers (see GetEnvIdentifiers) from
+	// `tmux show-environment`.  Only variables listed in tmux's
+	// `update-environment` option are available.
+	Env map[string]string
+}
+
+// Queries tmux for the attached client's terminal.  Runs tmux each time;
+// GetEnvIdentifiers & Detect reuse one answer per client.
+func QueryTmuxClient() (*TmuxClient, error) {
+
+	display := func(sFmt string) (string, error) {
+		out, E := exec.Command("tmux", "display", "-p", sFmt).Output()
+		return strings.TrimSpace(string(out)), E
+	}
+
+	C := &TmuxClient{Env: make(map[string]string)}
+
+	var E error
+	if C.TermName, E = display("#{client_termname}"); E != nil {
+		return nil, E
+	}
+
+	// EMPTY ON TMUX < 3.3
+	C.TermType, _ = display("#{client_termtype}")
+
+	// NAME=value, OR -NAME FOR REMOVED VARIABLES
+	out, E := exec.Command("tmux", "show-environment").Output()
+	if E == nil {
+		for _, ln := range strings.Split(string(out), "\n") {
+			if K, v, bOK := strings.Cut(ln, "="); bOK && isEnvIdentifier(K) {
+				C.Env[K] = strings.ToLower(strings.TrimSpace(v))
+			}
+		}
+	}
+
+	if C.TermName != "" {
+		C.Env["TERM"] = strings.ToLower(C.TermName)
+	}
+
+	return C, nil
+}
+
+var (
+	tmuxMu     sync.Mutex
+	tmuxKey    string // $TMUX & client the cached answer is for
+	tmuxCached bool
+	tmuxClient *TmuxClient
+	tmuxErr    error
+)
+
+/*
+QueryTmuxClient's result, queried once per $TMUX & attached client.
+Asks tmux which client is attached each time, as a session detached &
+reattached from another terminal has a new one.  Shared: don't modify.
+*/
+func cachedTmuxClient() (*TmuxClient, error) {
+
+	tmuxMu.Lock()
+	defer tmuxMu.Unlock()
+
+	out, _ := exec.Command("tmux", "display", "-p", "#{client_pid} #{client_tty}").Output()
+	K := os.Getenv("TMUX") + "\n" + strings.TrimSpace(string(out))
+	if !tmuxCached || (K != tmuxKey) {
+		tmuxClient, tmuxErr = QueryTmuxClient()
+		tmuxKey, tmuxCached = K, true
+	}
+
+	return tmuxClient, tmuxErr
+}
+
+// Identifies the outer terminal from #{client_termtype}, then the
+// client's environment identifiers.
+func (c *TmuxClient) Identify() TermID {
+
+	if c.TermType != "" {
+		if id := termFromXTVersion(c.TermType); id.Name != "" {
+			id.Source = "tmux:client_termtype"
+			return id
+		}
+	}
+
+	id := termFromEnv(c.Env)
+	if id.Name != "" {
+		id.Source = "tmux:" + strings.TrimPrefix(id.Source, "env:")
+	}
+
+	return id
+}
+
+// wraps a terminal query in tmux passthrough so the outer terminal answers it
+func tmuxWrapQuery(sRq string) string {
+	return TMUX_PASSTHROUGH_HDR + strings.ReplaceAll(sRq, "\x1b", "\x1b\x1b") + TMUX_PASSTHROUGH_FTR
+}
+
+/*
+NOTE: the calling program MUST be connected to an actual terminal for this to work
+
+Like TermRequestResponse, but wraps the request in tmux passthrough when
+running inside tmux, so the outer terminal answers rather than tmux.
+*/
+func TermRequestResponseOuter(fileIN, fileOUT *os.File, sRq string) ([]byte, error) {
+	return NewFileTermIO(fileIN, fileOUT).QueryOuter(context.Background(), sRq, TermQueryOpts{})
+}
+
+// Like Query, but wraps the request in tmux passthrough when running
+// inside tmux.  See TermRequestResponseOuter.
+func (t *TermIO) QueryOuter(ctx context.Context, sRq string, opts TermQueryOpts) ([]byte, error) {
+
+	if IsTmux() {
+		sRq = tmuxWrapQuery(sRq)
+	}
+
+	return t.Query(ctx, sRq, opts)
+}
@@ -0,0 +1,108 @@
+package rasterm
+
+import (
+	"os"
+	"strconv"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// Where terminal queries (and optionally image output) go.
+type TTYMode int
+
+const (
+	// os.Stdin & os.Stdout when both are terminals, otherwise the
+	// controlling terminal.  Covers `tool < in.txt`, `tool | less -R`
+	// & `go test`.
+	TTY_AUTO TTYMode = iota
+
+	// Always the controlling terminal.
+	TTY_FORCE
+
+	// Always os.Stdin & os.Stdout.
+	TTY_NEVER
+)
+
+func (m TTYMode) String() string {
+	switch m {
+	case TTY_AUTO:
+		return "auto"
+	case TTY_FORCE:
+		return "force"
+	case TTY_NEVER:
+		return "never"
+	}
+	return "TTYMode(" + strconv.Itoa(int(m)) + ")"
+}
+
+// Mode used by StdTermIO, and so by all functions that query the
+// terminal without taking a TermIO.
+var TTY_MODE = TTY_AUTO
+
+// controlling terminal, opened on first use & kept open
+var (
+	ttyOnce    sync.Once
+	ttyFileIN  *os.File
+	ttyFileOUT *os.File
+	ttyErr     error
+)
+
+func controllingTTY() (*os.File, *os.File, error) {
+	ttyOnce.Do(func() {
+		ttyFileIN, ttyFileOUT, ttyErr = openTTY()
+	})
+	return ttyFileIN, ttyFileOUT, ttyErr
+}
+
+func isTerminalFile(f *os.File) bool {
+	return (f != nil) && term.IsTerminal(int(f.Fd()))
+}
+
+/*
+Returns a TermIO per `mode`: os.Stdin & os.Stdout, or the controlling
+terminal (/dev/tty; CONIN$ & CONOUT$ on Windows).
+
+The controlling terminal is opened once and shared for the life of the
+process.  Errors only if TTY_FORCE can't open it.
+*/
+func OpenTermIO(mode TTYMode) (*TermIO, error) {
+
+	if (mode == TTY_NEVER) || ((mode == TTY_AUTO) && isTerminalFile(os.Stdin) && isTerminalFile(os.Stdout)) {
+		return NewFileTermIO(os.Stdin, os.Stdout), nil
+	}
+
+	fileIN, fileOUT, E := controllingTTY()
+	if E != nil {
+		if mode == TTY_FORCE {
+			return nil, E
+		}
+		return NewFileTermIO(os.Stdin, os.Stdout), nil
+	}
+
+	return NewFileTermIO(fileIN, fileOUT), nil
+}
+
+/*
+Returns where to write images per `mode`: os.Stdout, or the controlling
+terminal when os.Stdout isn't one (TTY_AUTO) or always (TTY_FORCE).
+
+Writing images to a pipe is sometimes wanted (e.g. `| less -R`), so
+callers choose whether to use this.
+*/
+func OutputTTY(mode TTYMode) (*os.File, error) {
+
+	if (mode == TTY_NEVER) || ((mode == TTY_AUTO) && isTerminalFile(os.Stdout)) {
+		return os.Stdout, nil
+	}
+
+	_, fileOUT, E := controllingTTY()
+	if E != nil {
+		if mode == TTY_FORCE {
+			return nil, E
+		}
+		return os.Stdout, nil
+	}
+
+	return fileOUT, nil
+}
@@ -0,0 +1,406 @@
+package rasterm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"io"
+	"sync"
+)
+
+// Options for WriteImage & Encoder.Encode.
+type WriteOpts struct {
+	// Target size in terminal cells.  With one of them zero, the other
+	// follows from the image's aspect ratio, but Rows alone is still
+	// narrowed to the terminal width.  Both zero: natural size, narrowed
+	// to the terminal width when known.
+	Cols int
+	Rows int
+
+	// How the image fits Cols x Rows, by default FIT_CONTAIN.
+	Fit FitMode
+
+	// Defaults to RESAMPLE_AUTO.
+	Resampler Resampler
+
+	// Pads the image with transparent pixels, right & below, to a whole
+	// number of cells, so the terminal's cursor advance matches
+	// Footprint.  Only where the image is scaled in-package.
+	Pad bool
+
+	// Scrolls room for the image into view before drawing, so drawing
+	// can't scroll the screen under it (see ReserveSpace).
+	Reserve bool
+
+	// Where WriteImage leaves the cursor.  Other than CURSOR_PROTOCOL,
+	// implies Reserve.
+	Cursor CursorPos
+
+	// Terminal capabilities to use.  WriteImage defaults to Detect's,
+	// found once per process.
+	Caps *Capabilities
+
+	// Color transparent pixels are flattened onto where the protocol
+	// can't carry alpha.  Defaults to Caps.Background, else black.
+	Matte color.Color
+}
+
+// cell size assumed when the terminal doesn't report one
+const (
+	defaultCellWidth  = 8
+	defaultCellHeight = 16
+)
+
+var (
+	capsMu     sync.Mutex
+	capsCached *Capabilities
+)
+
+// Detect's result for StdTermIO, found on first use
+func cachedCapabilities(ctx context.Context) (*Capabilities, error) {
+
+	capsMu.Lock()
+	defer capsMu.Unlock()
+
+	if capsCached != nil {
+		return capsCached, nil
+	}
+
+	C, E := Detect(ctx, DetectOpts{})
+	if E != nil {
+		return C, E
+	}
+
+	capsCached = C
+	return C, nil
+}
+
+/*
+Draws `iImg` at the cursor with the best encoder the terminal supports:
+any registered by the application, then Kitty, iTerm, Sixel (quantized
+to a built-in palette), and Unicode blocks (see BlocksWriteImage).
+
+If an encoder fails, the next one is tried.  Errors writing to `out`
+end the attempt.  Inside tmux or GNU screen, graphics are wrapped for
+passthrough (see PassthroughWriter).  With RASTERM_PROTOCOL=none,
+nothing is drawn.
+
+opts.Reserve & opts.Cursor place text after the image consistently
+across protocols, by the image's Footprint.
+*/
+func WriteImage(out io.Writer, iImg image.Image, opts WriteOpts) error {
+
+	// BEFORE DETECTION, WHICH MAY QUERY THE TERMINAL
+	if EnvOverrides().Protocol == PROTO_NONE {
+		return nil
+	}
+
+	ctx := context.Background()
+
+	if opts.Caps == nil {
+		var E error
+		if opts.Caps, E = cachedCapabilities(ctx); E != nil {
+			return E
+		}
+	}
+
+	var sErr []error
+	for _, enc := range Encoders() {
+
+		if !enc.Supports(opts.Caps) {
+			continue
+		}
+
+		buf := new(bytes.Buffer)
+		if E := enc.Encode(ctx, buf, iImg, opts); E != nil {
+			sErr = append(sErr, fmt.Errorf("%s: %w", enc.Name(), E))
+			continue
+		}
+
+		dst := out
+		if (enc.Name() != PROTO_BLOCKS) && (opts.Caps.Multiplexer != "") {
+			dst = PassthroughWriter(out)
+		}
+
+		pre, post := opts.cursorWrap(encoderFootprint(enc, iImg, opts))
+		if _, E := io.WriteString(out, pre); E != nil {
+			return E
+		}
+		if _, E := buf.WriteTo(dst); E != nil {
+			return E
+		}
+		if f, bOK := dst.(interface{ Flush() error }); bOK {
+			if E := f.Flush(); E != nil {
+				return E
+			}
+		}
+		_, E := io.WriteString(out, post)
+		return E
+	}
+
+	return errors.Join(sErr...)
+}
+
+// how to scale an image for display, or false to keep its size
+func (o WriteOpts) scaleOpts(b image.Rectangle) (ScaleOpts, bool) {
+
+	C := o.caps()
+	if (o.Cols > 0) || (o.Rows > 0) {
+
+		so := ScaleOpts{
+			Cols:      o.Cols,
+			Rows:      o.Rows,
+			Geometry:  C.Geometry,
+			Fit:       o.Fit,
+			Resampler: o.Resampler,
+		}
+
+		// ROWS ALONE: NO WIDER THAN THE TERMINAL
+		if (o.Cols <= 0) && (C.Geometry.Width > 0) && !b.Empty() {
+			_, ch := cellSize(C.Geometry)
+			if b.Dx()*o.Rows*ch > C.Geometry.Width*b.Dy() {
+				so.Rows, so.Width, so.Height = 0, C.Geometry.Width, o.Rows*ch
+				if so.Fit != FIT_NONE {
+					so.Fit = FIT_CONTAIN
+				}
+			}
+		}
+
+		return so, true
+	}
+
+	if (C.Geometry.Width <= 0) || (b.Dx() <= C.Geometry.Width) {
+		return ScaleOpts{}, false
+	}
+
+	return ScaleOpts{Width: C.Geometry.Width, Resampler: o.Resampler}, true
+}
+
+/*
+`iImg` scaled for display per opts: fit to Cols x Rows by the cell size
+in Caps.Geometry, or narrowed to the terminal width, then padded if
+opts.Pad.  `iImg` itself when nothing needs doing.
+*/
+func (o WriteOpts) scaleImage(iImg image.Image) image.Image {
+
+	if so, bOK := o.scaleOpts(iImg.Bounds()); bOK {
+		iImg = Scale(iImg, so)
+	}
+
+	if o.Pad {
+		cw, ch := cellSize(o.caps().Geometry)
+		b := iImg.Bounds()
+		iImg = padImage(iImg, roundUp(b.Dx(), cw), roundUp(b.Dy(), ch))
+	}
+
+	return iImg
+}
+
+// size of scaleImage's result, in pixels
+func (o WriteOpts) scaledSize(iImg image.Image) (int, int) {
+
+	b := iImg.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	if so, bOK := o.scaleOpts(b); bOK {
+		_, bPal := iImg.(*image.Paletted)
+		w, h = so.Size(b, bPal)
+	}
+
+	if o.Pad {
+		cw, ch := cellSize(o.caps().Geometry)
+		w, h = roundUp(w, cw), roundUp(h, ch)
+	}
+
+	return w, h
+}
+
+// whether sizing should be left to the terminal: it knows its cell size
+// when we don't, and can keep the aspect ratio itself
+func (o WriteOpts) terminalScales() bool {
+
+	g := o.caps().Geometry
+	bKnown := (g.CellWidth > 0) && (g.CellHeight > 0)
+	bSized := (o.Cols > 0) || (o.Rows > 0)
+
+	return bSized && !bKnown && ((o.Fit == FIT_CONTAIN) || (o.Fit == FIT_FILL))
+}
+
+// Sixel for any image: scaled to the target size, then quantized
+func sixelWriteAny(out io.Writer, iImg image.Image, C *Capabilities, opts WriteOpts) error {
+
+	if iImg.Bounds().Empty() {
+		return errors.New("empty image")
+	}
+
+	opts.Caps = C
+	iImg = opts.scaleImage(iImg)
+
+	if so, bOK := sixelMaxOpts(iImg.Bounds(), C, opts); bOK {
+		iImg = Scale(iImg, so)
+	}
+
+	if iImg.Bounds().Empty() {
+		return errors.New("empty image")
+	}
+
+	so := SixelOpts{Matte: opts.matte()}
+	so.Profile.MaxRegisters = C.SixelMaxColors
+
+	pI, bOK := iImg.(*image.Paletted)
+	if !bOK {
+		pI = sixelQuantize(iImg, opts.matte())
+	}
+
+	return SixelWriteImageWithOptions(out, pI, so)
+}
+
+// shrinks what's too big for the terminal's sixel graphics
+func sixelMaxOpts(b image.Rectangle, C *Capabilities, opts WriteOpts) (ScaleOpts, bool) {
+
+	if (C.SixelMaxWidth <= 0) || (C.SixelMaxHeight <= 0) ||
+		((b.Dx() <= C.SixelMaxWidth) && (b.Dy() <= C.SixelMaxHeight)) {
+		return ScaleOpts{}, false
+	}
+
+	return ScaleOpts{Width: C.SixelMaxWidth, Height: C.SixelMaxHeight, Resampler: opts.Resampler}, true
+}
+
+/*
+Dithers to the Plan 9 palette.  Partly transparent pixels are flattened
+onto `matte` first, as no palette entry carries them; fully transparent
+ones stay transparent.
+*/
+func sixelQuantize(iImg image.Image, matte color.Color) *image.Paletted {
+
+	b := iImg.Bounds()
+
+	pal := color.Palette(palette.Plan9)
+	if o, bOK := iImg.(interface{ Opaque() bool }); !bOK || !o.Opaque() {
+
+		pal = append(append(color.Palette{}, palette.Plan9[:255]...), color.Transparent)
+
+		flat := flattenAlpha(iImg, matte).(*image.RGBA)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if _, _, _, a := iImg.At(x, y).RGBA(); a == 0 {
+					flat.SetRGBA(x, y, color.RGBA{})
+				}
+			}
+		}
+		iImg = flat
+	}
+
+	pI := image.NewPaletted(b, pal)
+	draw.FloydSteinberg.Draw(pI, b, iImg, b.Min)
+
+	return pI
+}
+
+// terminal cell size in pixels, or a typical one
+func cellSize(g TermGeometry) (int, int) {
+
+	if (g.CellWidth > 0) && (g.CellHeight > 0) {
+		return g.CellWidth, g.CellHeight
+	}
+
+	return defaultCellWidth, defaultCellHeight
+}
+
+// cells covered at natural size, narrowed to `maxCols` if > 0
+func naturalCells(b image.Rectangle, cw, ch, maxCols int) (int, int) {
+
+	cols := (b.Dx() + cw - 1) / cw
+	if (maxCols > 0) && (cols > maxCols) {
+		return fitCells(b, maxCols, 0, cw, ch)
+	}
+
+	return cols, (b.Dy() + ch - 1) / ch
+}
+
+// fills a zero `cols` or `rows` from the other, keeping aspect ratio
+func fitCells(b image.Rectangle, cols, rows, cw, ch int) (int, int) {
+
+	if b.Empty() {
+		return cols, rows
+	}
+
+	switch {
+	case (cols > 0) && (rows > 0):
+	case cols > 0:
+		rows = (cols*cw*b.Dy() + b.Dx()*ch/2) / (b.Dx() * ch)
+	case rows > 0:
+		cols = (rows*ch*b.Dx() + b.Dy()*cw/2) / (b.Dy() * cw)
+	}
+
+	if cols < 1 {
+		cols = 1
+	}
+	if rows < 1 {
+		rows = 1
+	}
+
+	return cols, rows
+}
+
+/*
+`iImg` on a w x h canvas, top-left aligned, transparent elsewhere.
+Paletted images stay paletted where the palette has room.
+*/
+func padImage(iImg image.Image, w, h int) image.Image {
+
+	b := iImg.Bounds()
+	if (b.Dx() >= w) && (b.Dy() >= h) {
+		return iImg
+	}
+	r := image.Rect(0, 0, w, h)
+
+	if pI, bOK := iImg.(*image.Paletted); bOK {
+
+		ixT := -1
+		for ix, c := range pI.Palette {
+			if _, _, _, a := c.RGBA(); a == 0 {
+				ixT = ix
+				break
+			}
+		}
+
+		pal := pI.Palette
+		if (ixT < 0) && (len(pal) < 256) {
+			pal = append(append(color.Palette{}, pal...), color.Transparent)
+			ixT = len(pal) - 1
+		}
+
+		if ixT >= 0 {
+			dst := image.NewPaletted(r, pal)
+			for ix := range dst.Pix {
+				dst.Pix[ix] = uint8(ixT)
+			}
+			for y := 0; y < b.Dy(); y++ {
+				copy(dst.Pix[y*dst.Stride:], pI.Pix[pI.PixOffset(b.Min.X, b.Min.Y+y):][:b.Dx()])
+			}
+			return dst
+		}
+	}
+
+	dst := image.NewRGBA(r)
+	draw.Draw(dst, b.Sub(b.Min), iImg, b.Min, draw.Src)
+	return dst
+}
+
+// `n` rounded up to a multiple of `m`
+func roundUp(n, m int) int {
+	return (n + m - 1) / m * m
+}
+
+func max1(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
@@ -0,0 +1,242 @@
+package rasterm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"strings"
+	"testing"
+)
+
+// too large for JPEG, cheap to sample
+type wideImage struct{ image.Image }
+
+func (wideImage) Bounds() image.Rectangle { return image.Rect(0, 0, 70000, 2) }
+
+func TestWriteImage(pT *testing.T) {
+
+	clearTermEnv(pT)
+
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for ix := range img.Pix {
+		img.Pix[ix] = 0xFF
+	}
+
+	caps := func(sP ...Protocol) *Capabilities {
+		return &Capabilities{
+			Protocols: sP,
+			Geometry:  TermGeometry{Cols: 80, Rows: 24, Width: 800, Height: 480, CellWidth: 10, CellHeight: 20},
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if E := WriteImage(buf, img, WriteOpts{Cols: 10, Caps: caps(PROTO_KITTY, PROTO_SIXEL)}); E != nil {
+		pT.Fatal(E)
+	}
+	if s := buf.String(); !strings.HasPrefix(s, KITTY_IMG_HDR) || strings.Contains(s, "c=10") {
+		pT.Errorf("expected kitty, scaled in-package: %.40q", s)
+	}
+
+	// UNKNOWN CELL SIZE: TERMINAL SCALES
+	buf.Reset()
+	unk := &Capabilities{Protocols: []Protocol{PROTO_KITTY}}
+	if E := WriteImage(buf, img, WriteOpts{Cols: 10, Rows: 10, Caps: unk}); E != nil {
+		pT.Fatal(E)
+	}
+	if s := buf.String(); !strings.Contains(s, "c=10;") {
+		pT.Errorf("expected kitty, terminal-scaled by columns: %.40q", s)
+	}
+
+	// 10 COLS x 10 PX = 100 PX WIDE, 50 PX HIGH
+	buf.Reset()
+	if E := WriteImage(buf, img, WriteOpts{Cols: 10, Caps: caps(PROTO_SIXEL)}); E != nil {
+		pT.Fatal(E)
+	}
+	if s := buf.String(); !strings.HasPrefix(s, "\x1bP0;1q\"1;1;100;50") {
+		pT.Errorf("expected scaled sixel: %.40q", s)
+	}
+
+	// 40 x 20 PX = 4 x 1 CELLS
+	buf.Reset()
+	if E := WriteImage(buf, img, WriteOpts{Caps: caps()}); E != nil {
+		pT.Fatal(E)
+	}
+	if s := buf.String(); (strings.Count(s, "\n") != 1) || (strings.Count(s, "▀") != 4) {
+		pT.Errorf("expected 4 x 1 blocks: %q", s)
+	}
+
+	// JPEG FAILS, SIXEL FOLLOWS
+	buf.Reset()
+	wide := wideImage{image.NewUniform(color.White)}
+	C := &Capabilities{Protocols: []Protocol{PROTO_ITERM, PROTO_SIXEL}, SixelMaxWidth: 800, SixelMaxHeight: 480}
+	if E := WriteImage(buf, wide, WriteOpts{Caps: C}); E != nil {
+		pT.Fatal(E)
+	}
+	if s := buf.String(); !strings.HasPrefix(s, "\x1bP0;1q\"1;1;800;1") {
+		pT.Errorf("expected sixel fallback: %.40q", s)
+	}
+
+	// ROWS ALONE, 3 x 20 PX: 2400 PX WIDE, NARROWED TO 800 x 20
+	buf.Reset()
+	long := image.NewRGBA(image.Rect(0, 0, 2000, 50))
+	if E := WriteImage(buf, long, WriteOpts{Rows: 3, Caps: caps(PROTO_SIXEL)}); E != nil {
+		pT.Fatal(E)
+	}
+	if s := buf.String(); !strings.HasPrefix(s, "\x1bP0;1q\"1;1;800;20") {
+		pT.Errorf("expected sixel narrowed to the terminal: %.40q", s)
+	}
+
+	pT.Setenv(ENV_PROTOCOL, "none")
+	buf.Reset()
+	if E := WriteImage(buf, img, WriteOpts{Caps: caps(PROTO_KITTY)}); (E != nil) || (buf.Len() > 0) {
+		pT.Errorf("expected nothing: %v, %.40q", E, buf.String())
+	}
+
+	// NOT EVEN DETECTION
+	capsMu.Lock()
+	saved := capsCached
+	capsCached = nil
+	capsMu.Unlock()
+	defer func() {
+		capsMu.Lock()
+		capsCached = saved
+		capsMu.Unlock()
+	}()
+
+	if E := WriteImage(buf, img, WriteOpts{}); (E != nil) || (buf.Len() > 0) || (capsCached != nil) {
+		pT.Errorf("expected no detection: %v, %.40q", E, buf.String())
+	}
+}
+
+type testEncoder struct{ fail bool }
+
+func (testEncoder) Name() Protocol { return "acme" }
+
+func (testEncoder) Supports(C *Capabilities) bool {
+	return C.Supports("acme") || (C.TermName == "acme-term")
+}
+
+func (e testEncoder) Encode(ctx context.Context, out io.Writer, iImg image.Image, opts WriteOpts) error {
+	if e.fail {
+		return errors.New("acme failed")
+	}
+	_, E := fmt.Fprintf(out, "ACME %dx%d", opts.Cols, opts.Rows)
+	return E
+}
+
+func TestEncoderRegistry(pT *testing.T) {
+
+	clearTermEnv(pT)
+
+	saved := Encoders()
+	pT.Cleanup(func() {
+		encMu.Lock()
+		encoders = saved
+		encMu.Unlock()
+	})
+
+	if _, bOK := Lookup("acme"); bOK {
+		pT.Fatal("unregistered encoder found")
+	}
+
+	Register(testEncoder{})
+	if enc, bOK := Lookup("acme"); !bOK || (enc.Name() != "acme") {
+		pT.Fatal("registered encoder not found")
+	}
+
+	// APPLICATION ENCODER PREFERRED WHERE SUPPORTED
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	C := &Capabilities{TermName: "acme-term", Protocols: []Protocol{PROTO_KITTY}}
+
+	buf := new(bytes.Buffer)
+	if E := WriteImage(buf, img, WriteOpts{Cols: 3, Rows: 2, Caps: C}); (E != nil) || (buf.String() != "ACME 3x2") {
+		pT.Errorf("expected acme: %v, %.40q", E, buf.String())
+	}
+
+	// REPLACED IN PLACE; FAILURE FALLS BACK TO KITTY
+	Register(testEncoder{fail: true})
+	if n := len(Encoders()); n != len(saved)+1 {
+		pT.Errorf("expected %d encoders, have %d", len(saved)+1, n)
+	}
+
+	buf.Reset()
+	if E := WriteImage(buf, img, WriteOpts{Caps: C}); (E != nil) || !strings.HasPrefix(buf.String(), KITTY_IMG_HDR) {
+		pT.Errorf("expected kitty: %v, %.40q", E, buf.String())
+	}
+
+	// RASTERM_PROTOCOL ACCEPTS REGISTERED NAMES
+	pT.Setenv(ENV_PROTOCOL, "acme")
+	if O := EnvOverrides(); (O.Protocol != "acme") || (len(O.Invalid) != 0) {
+		pT.Errorf("override not accepted: %+v", O)
+	}
+}
+
+func TestWriteImageMatte(pT *testing.T) {
+
+	clearTermEnv(pT)
+	pT.Setenv("COLORTERM", "truecolor")
+
+	// FULLY TRANSPARENT: ALL MATTE
+	img := image.NewRGBA(image.Rect(0, 0, 1, 2))
+
+	type tcase struct {
+		C     *Capabilities
+		matte color.Color
+		exp   string
+	}
+
+	sTests := []tcase{
+		{&Capabilities{}, nil, "38;2;0;0;0;48;2;0;0;0m"},
+		{&Capabilities{Background: color.RGBA{255, 0, 0, 255}}, nil, "38;2;255;0;0;48;2;255;0;0m"},
+		{&Capabilities{Background: color.RGBA{255, 0, 0, 255}}, color.White, "38;2;255;255;255;48;2;255;255;255m"},
+	}
+
+	buf := new(bytes.Buffer)
+	for _, t := range sTests {
+		buf.Reset()
+		if E := WriteImage(buf, img, WriteOpts{Caps: t.C, Matte: t.matte}); E != nil {
+			pT.Fatal(E)
+		}
+		if s := buf.String(); !strings.Contains(s, t.exp) {
+			pT.Errorf("%v / %v: expected %q in %q", t.C.Background, t.matte, t.exp, s)
+		}
+	}
+}
+
+func TestWriteImageSixelMatte(pT *testing.T) {
+
+	clearTermEnv(pT)
+
+	// HALF-TRANSPARENT RED ON A WHITE BACKGROUND: DITHERED PINKS, NOT DARK REDS
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 6))
+	draw.Draw(img, img.Rect, image.NewUniform(color.NRGBA{255, 0, 0, 128}), image.Point{}, draw.Src)
+
+	buf := new(bytes.Buffer)
+	C := &Capabilities{Protocols: []Protocol{PROTO_SIXEL}, Background: color.White}
+	if E := WriteImage(buf, img, WriteOpts{Caps: C}); E != nil {
+		pT.Fatal(E)
+	}
+
+	var sumG, n int
+	for _, row := range sixelTestDecode(pT, buf.String()) {
+		for _, spec := range row {
+			var r, g, b int
+			if _, E := fmt.Sscanf(spec, "2;%d;%d;%d", &r, &g, &b); E != nil {
+				pT.Fatalf("bad register %q: %v", spec, E)
+			}
+			if (r < 85) || (g < 30) || (g > 70) || (b != g) {
+				pT.Errorf("register %q: expected a pink near 100;50;50", spec)
+			}
+			sumG, n = sumG+g, n+1
+		}
+	}
+
+	if (n != 24) || (sumG/n < 40) || (sumG/n > 60) {
+		pT.Errorf("expected 24 pixels averaging 50%% green, got %d at %d", n, sumG/max1(n))
+	}
+}